@@ -0,0 +1,120 @@
+package sobel
+
+import (
+	"image"
+
+	"github.com/bksworm/sobel/internal/asm"
+)
+
+// FilterGrayAsm is FilterGraySimd without cgo: 3x3 Sobel with the L2
+// magnitude, computed by amd64 assembly with AVX2 or SSE4.1 when the CPU
+// has them and by go code otherwise. The options are the ones of Filter.
+func FilterGrayAsm(grayImg *image.Gray, opts ...Option) *image.Gray {
+	o, own := legacyOptions("FilterGrayAsm", Sobel, opts)
+	if !own {
+		filtered, _ := applyLegacy(grayImg, &o, BackendAsm)
+		return filtered
+	}
+	return filterGrayAsmBorder(grayImg, &o)
+}
+
+// filterGrayAsmBorder is the code of FilterGrayAsm
+func filterGrayAsmBorder(grayImg *image.Gray, o *options) *image.Gray {
+	return withBorder(o.smooth(grayImg), kernelSize/2, o, func(img *image.Gray) *image.Gray {
+		return filterGrayAsm(img, nil)
+	})
+}
+
+// filterGrayAsm combines the responses with mag, nil is the saturated
+// L2 of asm.MagnitudeRow, which is the one of magnitudeMath
+func filterGrayAsm(grayImg *image.Gray, mag magnitudeFunc) *image.Gray {
+	b := grayImg.Bounds()
+	filtered := image.NewGray(b)
+	asmRows(grayImg, func(y int, dx, dy []int16) {
+		dst := filtered.Pix[filtered.PixOffset(b.Min.X, y):][:b.Dx()]
+		if mag == nil {
+			asm.MagnitudeRow(dx, dy, dst)
+			return
+		}
+		for x := 1; x < len(dst)-1; x++ {
+			dst[x] = mag(abs16(dx[x]), abs16(dy[x]))
+		}
+	})
+	return filtered
+}
+
+func abs16(v int16) uint32 {
+	if v < 0 {
+		return uint32(-int32(v))
+	}
+	return uint32(v)
+}
+
+// asmRows calls row for every image row that has complete
+// neighbourhoods, top to bottom, with the 3x3 Sobel responses:
+// dx[x-min.X] for x from min.X+1 to max.X-2
+func asmRows(grayImg *image.Gray, row func(y int, dx, dy []int16)) {
+	b := grayImg.Bounds()
+	w := b.Dx()
+	if w < 3 || b.Dy() < 3 {
+		return
+	}
+	dx, dy := make([]int16, w), make([]int16, w)
+	for y := b.Min.Y + 1; y < b.Max.Y-1; y++ {
+		above := grayImg.Pix[grayImg.PixOffset(b.Min.X, y-1):][:w]
+		mid := grayImg.Pix[grayImg.PixOffset(b.Min.X, y):][:w]
+		below := grayImg.Pix[grayImg.PixOffset(b.Min.X, y+1):][:w]
+		asm.SobelRow(above, mid, below, dx, dy)
+		row(y, dx, dy)
+	}
+}
+
+// asmBackend runs 3x3 Sobel with asm.SobelRow, its speed depends on the
+// instruction set the CPU has
+type asmBackend struct{}
+
+func init() {
+	mustRegister(asmBackend{})
+}
+
+func (asmBackend) Name() string {
+	return BackendAsm
+}
+
+func (asmBackend) Capabilities() Capabilities {
+	speed := 40
+	if asm.Level == asm.Go {
+		speed = 25
+	}
+	return Capabilities{Speed: speed, Instructions: asm.Level}
+}
+
+func (asmBackend) Supports(flt FilterType) bool {
+	return simdSupports(flt)
+}
+
+func (b asmBackend) Gradient(img *image.Gray, f *FilterSpec, g *Gradient) error {
+	if err := f.check(b); err != nil {
+		return err
+	}
+	min := img.Bounds().Min
+	asmRows(img, func(y int, dx, dy []int16) {
+		i := g.Offset(min.X, y)
+		for x := 1; x < len(dx)-1; x++ {
+			g.Dx[i+x] = float32(dx[x])
+			g.Dy[i+x] = float32(dy[x])
+		}
+	})
+	return nil
+}
+
+func (b asmBackend) Magnitude(img *image.Gray, f *FilterSpec) (*image.Gray, error) {
+	if err := f.check(b); err != nil {
+		return nil, err
+	}
+	var mag magnitudeFunc
+	if f.mapped || f.Magnitude != MagnitudeL2 {
+		mag = f.magnitude(magnitudeMath)
+	}
+	return filterGrayAsm(img, mag), nil
+}
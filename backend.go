@@ -0,0 +1,294 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"sync"
+)
+
+// BackendFastest is accepted by WithBackend for the fastest registered
+// backend that runs the selected filter, see FastestBackend
+const BackendFastest = "fastest"
+
+// Backend is an implementation of the filters of Apply and ApplyGradient.
+// Conversion to gray, pre-smoothing, borders, workers and MappingNormalize
+// are done by the package, a backend only computes the pixels that have a
+// complete neighbourhood of img. img may be a band of a larger image with
+// the rows above and below it, so pixels outside of img bounds must not
+// be written. Backends are added with RegisterBackend.
+type Backend interface {
+	// Name is the name WithBackend selects the backend by
+	Name() string
+	// Capabilities describes what the backend runs besides kernels
+	Capabilities() Capabilities
+	// Supports reports if the backend has the built-in kernel flt
+	Supports(flt FilterType) bool
+	// Gradient writes the X and Y responses of f into g, whose bounds
+	// contain the ones of img
+	Gradient(img *image.Gray, f *FilterSpec, g *Gradient) error
+	// Magnitude returns an image with img bounds: the mapped magnitude,
+	// the strongest compass response or, with f.Convolution, the mapped
+	// X response. Pixels without a complete neighbourhood are 0.
+	Magnitude(img *image.Gray, f *FilterSpec) (*image.Gray, error)
+}
+
+// Capabilities describes what a Backend runs besides its kernels
+type Capabilities struct {
+	UserKernels  bool   //WithKernels and WithOrder other than 1
+	Convolution  bool   //WithConvolution and Sharpen
+	Speed        int    //relative speed, FastestBackend picks the largest
+	Instructions string //the instruction set it runs with, empty if it doesn't matter
+}
+
+// FilterSpec is the filter the options select, as a Backend runs it
+type FilterSpec struct {
+	Kernel      FilterType //the built-in kernel, unless Custom
+	Custom      bool       //user kernels or derivatives of another order
+	KX, KY      *Kernel    //one may be nil for user kernels, both are nil for compass filters
+	Masks       []*Kernel  //the masks of compass filters, the response is the strongest one
+	Convolution bool       //Magnitude maps the signed response of KX
+	Magnitude   Magnitude
+
+	user          bool //user kernels, Custom is true for orders too
+	order         int
+	mapped        bool //a mapping other than MappingSaturate
+	scale, offset float64
+}
+
+// NewFilterSpec returns the FilterSpec of opts as Apply passes it to the
+// backend, e.g. to run a Backend directly. It takes the kernel,
+// magnitude, convolution and mapping options, the others are not passed
+// to backends.
+func NewFilterSpec(opts ...Option) (*FilterSpec, error) {
+	o := newOptions(opts)
+	if err := o.only("NewFilterSpec", optSpec); err != nil {
+		return nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return o.filter(), nil
+}
+
+// Radius returns the number of pixels on each side the filter needs
+func (f *FilterSpec) Radius() int {
+	switch {
+	case f.Masks != nil:
+		return 1
+	case f.Convolution:
+		return f.KX.Radius()
+	}
+	return kernelsRadius(f.KX, f.KY)
+}
+
+// Norm combines X and Y responses with the magnitude mode of f
+func (f *FilterSpec) Norm(dx, dy float64) float64 {
+	return f.Magnitude.norm(dx, dy)
+}
+
+// Map maps a magnitude or response to a pixel as the options say
+func (f *FilterSpec) Map(v float64) uint8 {
+	return mapPixel(v, f.scale, f.offset)
+}
+
+// magnitude is options.magnitude for f
+func (f *FilterSpec) magnitude(l2 magnitudeFunc) magnitudeFunc {
+	return magnitudeOf(f.Magnitude, f.mapped, f.scale, f.offset, l2)
+}
+
+// check returns an ErrUnsupported error if b doesn't run f
+func (f *FilterSpec) check(b Backend) error {
+	caps := b.Capabilities()
+	switch {
+	case f.user && !caps.UserKernels:
+		return unsupported(b.Name(), "user kernels")
+	case !f.user && !b.Supports(f.Kernel):
+		return unsupported(b.Name(), f.Kernel.String()+" kernel")
+	case f.order != 1 && !caps.UserKernels:
+		return unsupported(b.Name(), fmt.Sprintf("derivative order %d", f.order))
+	case f.Convolution && !caps.Convolution:
+		return unsupported(b.Name(), "convolution")
+	}
+	return nil
+}
+
+// filter returns the FilterSpec of the options, they must be valid
+func (o *options) filter() *FilterSpec {
+	f := &FilterSpec{
+		Kernel:      o.kernel,
+		Custom:      o.custom() || o.order != 1,
+		Convolution: o.convolution(),
+		Magnitude:   o.mag,
+		user:        o.custom(),
+		order:       o.order,
+		mapped:      o.mapping != MappingSaturate,
+	}
+	f.scale, f.offset = o.linear(0)
+	if masks := o.kernel.compass(); !o.custom() && masks != nil {
+		f.Masks = masks
+		return f
+	}
+	f.KX, f.KY = o.kernels()
+	return f
+}
+
+var registry = struct {
+	sync.RWMutex
+	backends map[string]Backend
+}{backends: map[string]Backend{}}
+
+// RegisterBackend adds b to the backends WithBackend selects from, the
+// name must be new
+func RegisterBackend(b Backend) error {
+	if b == nil {
+		return fmt.Errorf("sobel: nil backend")
+	}
+	name := b.Name()
+	if name == "" || name == BackendFastest {
+		return fmt.Errorf("sobel: invalid backend name %q", name)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.backends[name]; ok {
+		return fmt.Errorf("sobel: backend %q is already registered", name)
+	}
+	registry.backends[name] = b
+	return nil
+}
+
+// mustRegister registers the backends of the package
+func mustRegister(b Backend) {
+	if err := RegisterBackend(b); err != nil {
+		panic(err)
+	}
+}
+
+// LookupBackend returns the backend registered with name
+func LookupBackend(name string) (Backend, error) {
+	registry.RLock()
+	b, ok := registry.backends[name]
+	registry.RUnlock()
+	if !ok {
+		if name == BackendSimd {
+			return nil, fmt.Errorf("sobel: simd backend is not built in, it needs cgo and the libsimd build tag: %w", ErrUnsupported)
+		}
+		return nil, fmt.Errorf("sobel: unknown backend %q", name)
+	}
+	return b, nil
+}
+
+// Backends returns the registered backends, fastest first
+func Backends() []Backend {
+	registry.RLock()
+	list := make([]Backend, 0, len(registry.backends))
+	for _, b := range registry.backends {
+		list = append(list, b)
+	}
+	registry.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		si, sj := list[i].Capabilities().Speed, list[j].Capabilities().Speed
+		if si != sj {
+			return si > sj
+		}
+		return list[i].Name() < list[j].Name()
+	})
+	return list
+}
+
+// FastestBackend returns the fastest registered backend that runs the
+// filter opts select, it takes the options of NewFilterSpec
+func FastestBackend(opts ...Option) (Backend, error) {
+	o := newOptions(opts)
+	if err := o.only("FastestBackend", optSpec); err != nil {
+		return nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return fastest(o.filter(), func(Backend) bool { return true })
+}
+
+// fastest returns the fastest backend running f that ok accepts
+func fastest(f *FilterSpec, ok func(Backend) bool) (Backend, error) {
+	for _, b := range Backends() {
+		if ok(b) && f.check(b) == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("sobel: no backend runs the filter: %w", ErrUnsupported)
+}
+
+// selectBackend resolves the backend of valid options, one that ok
+// accepts for BackendFastest, and checks that it runs the filter
+func (o *options) selectBackend(ok func(Backend) bool) (Backend, error) {
+	if o.backend == BackendFastest {
+		return fastest(o.filter(), ok)
+	}
+	b, err := LookupBackend(o.backend)
+	if err != nil {
+		return nil, err
+	}
+	return b, o.filter().check(b)
+}
+
+// applyBackend runs b on a smoothed gray image, MappingNormalize needs the
+// maximum magnitude of the whole image and runs on the gradient
+func applyBackend(b Backend, grayImg *image.Gray, o *options) (*image.Gray, error) {
+	f := o.filter()
+	if o.mapping == MappingNormalize {
+		g, err := gradientBackend(b, grayImg, o)
+		if err != nil {
+			return nil, err
+		}
+		return g.magnitudeImage(o), nil
+	}
+	var errs firstError
+	filtered := withBorder(grayImg, f.Radius(), o, func(img *image.Gray) *image.Gray {
+		res, err := b.Magnitude(img, f)
+		if err != nil {
+			errs.set(err)
+			return image.NewGray(img.Bounds())
+		}
+		return res
+	})
+	if err := errs.get(); err != nil {
+		return nil, err
+	}
+	return filtered, nil
+}
+
+// gradientBackend is applyBackend for ApplyGradient
+func gradientBackend(b Backend, grayImg *image.Gray, o *options) (*Gradient, error) {
+	f := o.filter()
+	var errs firstError
+	g := gradientBorder(grayImg, f.Radius(), o, func(img *image.Gray, g *Gradient) {
+		if err := b.Gradient(img, f, g); err != nil {
+			errs.set(err)
+		}
+	})
+	if err := errs.get(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// firstError keeps the first error of bands run concurrently
+type firstError struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (e *firstError) set(err error) {
+	e.mu.Lock()
+	if e.err == nil {
+		e.err = err
+	}
+	e.mu.Unlock()
+}
+
+func (e *firstError) get() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
@@ -0,0 +1,172 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// GaussianBlur smooths grayImg by a Gaussian of sigma cut at radius
+// pixels from the centre. Radius 0 means 3*sigma, sigma 0 is derived from
+// the radius the way OpenCV does it; one of them must be given. It takes
+// the border and workers options, pixels within the radius from the edge
+// follow the border policy like the filters do.
+func GaussianBlur(grayImg *image.Gray, sigma float64, radius int, opts ...Option) (*image.Gray, error) {
+	o := newOptions(opts)
+	if err := o.only("GaussianBlur", optBorder|optWorkers); err != nil {
+		return nil, err
+	}
+	if err := validateBlur(sigma, radius, false); err != nil {
+		return nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return gaussianBlur(grayImg, sigma, radius, &o), nil
+}
+
+// BoxBlur replaces every pixel by the mean of its (2*radius+1)² square
+// neighbourhood. It takes the same time for any radius, and the border
+// and workers options.
+func BoxBlur(grayImg *image.Gray, radius int, opts ...Option) (*image.Gray, error) {
+	o := newOptions(opts)
+	if err := o.only("BoxBlur", optBorder|optWorkers); err != nil {
+		return nil, err
+	}
+	if err := validateBlur(0, radius, true); err != nil {
+		return nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return withBorder(grayImg, radius, &o, func(img *image.Gray) *image.Gray {
+		return filterGrayBox(img, radius)
+	}), nil
+}
+
+func validateBlur(sigma float64, radius int, box bool) error {
+	if sigma < 0 || math.IsNaN(sigma) || math.IsInf(sigma, 0) {
+		return fmt.Errorf("sobel: invalid blur sigma %v", sigma)
+	}
+	if radius < 0 || box && radius == 0 || !box && sigma == 0 && radius == 0 {
+		return fmt.Errorf("sobel: invalid blur radius %d", radius)
+	}
+	return nil
+}
+
+// smooth runs the pre-smoothing selected by WithBlur or WithBoxBlur.
+// Pixels outside of the image are reflected (BorderReflect101) so that
+// the edges of the image don't become edges of the filter. Images
+// without pixels have nothing to reflect and are returned as they are.
+func (o *options) smooth(grayImg *image.Gray) *image.Gray {
+	if o.sigma <= 0 && o.blurRadius <= 0 || grayImg.Rect.Empty() {
+		return grayImg
+	}
+	bo := *o
+	bo.border = BorderReflect101
+	if o.box {
+		return withBorder(grayImg, o.blurRadius, &bo, func(img *image.Gray) *image.Gray {
+			return filterGrayBox(img, o.blurRadius)
+		})
+	}
+	return gaussianBlur(grayImg, o.sigma, o.blurRadius, &bo)
+}
+
+func (o *options) validateSmooth() error {
+	if o.sigma == 0 && o.blurRadius == 0 {
+		return nil
+	}
+	return validateBlur(o.sigma, o.blurRadius, o.box)
+}
+
+// gaussianKernel returns the separable Gaussian kernel, its weights sum
+// to 1. Either sigma or radius may be 0.
+func gaussianKernel(sigma float64, radius int) *Kernel {
+	if radius == 0 {
+		radius = int(math.Ceil(3 * sigma))
+		if radius < 1 {
+			radius = 1
+		}
+	}
+	if sigma == 0 {
+		sigma = 0.3*(float64(radius)-1) + 0.8
+	}
+	w := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range w {
+		d := float64(i - radius)
+		w[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += w[i]
+	}
+	for i := range w {
+		w[i] /= sum
+	}
+	k, _ := NewSeparableKernel(w, w)
+	return k
+}
+
+// boxKernel returns the separable kernel of the mean of (2r+1)² pixels
+func boxKernel(r int) *Kernel {
+	n := 2*r + 1
+	w := make([]float64, n)
+	ones := make([]float64, n)
+	for i := range w {
+		w[i], ones[i] = 1/float64(n*n), 1
+	}
+	k, _ := NewSeparableKernel(w, ones)
+	return k
+}
+
+func gaussianBlur(grayImg *image.Gray, sigma float64, radius int, o *options) *image.Gray {
+	k := gaussianKernel(sigma, radius)
+	return withBorder(grayImg, k.Radius(), o, func(img *image.Gray) *image.Gray {
+		return filterGrayKernels(img, k, nil, magnitudeL1)
+	})
+}
+
+// filterGrayBox averages the neighbourhood of every pixel that has a
+// complete one with running sums: a horizontal sum per row, updated by
+// one pixel in and one out, and a vertical sum of the last 2r+1 of them
+func filterGrayBox(grayImg *image.Gray, r int) *image.Gray {
+	b := grayImg.Bounds()
+	filtered := image.NewGray(b)
+	w, h, n := b.Dx(), b.Dy(), 2*r+1
+	if w < n || h < n {
+		return filtered
+	}
+	area := uint32(n * n)
+	rows := make([][]uint32, n)
+	for i := range rows {
+		rows[i] = make([]uint32, w)
+	}
+	col := make([]uint32, w)
+	for y := 0; y < h; y++ {
+		row := rows[y%n]
+		if y >= n {
+			for x := r; x < w-r; x++ {
+				col[x] -= row[x]
+			}
+		}
+		src := grayImg.Pix[grayImg.PixOffset(b.Min.X, b.Min.Y+y):][:w]
+		var s uint32
+		for x := 0; x < n; x++ {
+			s += uint32(src[x])
+		}
+		row[r] = s
+		for x := r + 1; x < w-r; x++ {
+			s += uint32(src[x+r]) - uint32(src[x-r-1])
+			row[x] = s
+		}
+		for x := r; x < w-r; x++ {
+			col[x] += row[x]
+		}
+		if y < n-1 {
+			continue
+		}
+		dst := filtered.Pix[filtered.PixOffset(b.Min.X, b.Min.Y+y-r):][:w]
+		for x := r; x < w-r; x++ {
+			dst[x] = uint8((col[x] + area/2) / area)
+		}
+	}
+	return filtered
+}
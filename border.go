@@ -0,0 +1,189 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+)
+
+// Border selects what happens with pixels whose kernel window leaves the image
+type Border int
+
+const (
+	BorderNone       Border = iota //such pixels are not computed and stay 0
+	BorderReplicate                //aaa|abcdefgh|hhh
+	BorderCrop                     //such pixels are dropped, the output is smaller
+	BorderConstant                 //vvv|abcdefgh|vvv, v is set by WithBorderValue
+	BorderReflect                  //cba|abcdefgh|hgf
+	BorderReflect101               //dcb|abcdefgh|gfe
+	BorderWrap                     //fgh|abcdefgh|abc
+)
+
+func (b Border) String() string {
+	switch b {
+	case BorderNone:
+		return "None"
+	case BorderReplicate:
+		return "Replicate"
+	case BorderCrop:
+		return "Crop"
+	case BorderConstant:
+		return "Constant"
+	case BorderReflect:
+		return "Reflect"
+	case BorderReflect101:
+		return "Reflect101"
+	case BorderWrap:
+		return "Wrap"
+	}
+	return fmt.Sprintf("Border(%d)", int(b))
+}
+
+func (b Border) valid() bool {
+	return b >= BorderNone && b <= BorderWrap
+}
+
+// borderIndex maps i, which may be outside of [0, n), into [0, n).
+// It is not used for BorderConstant, there is nothing to map to.
+func borderIndex(i, n int, b Border) int {
+	for i < 0 || i >= n {
+		switch b {
+		case BorderReplicate:
+			if i < 0 {
+				i = 0
+			} else {
+				i = n - 1
+			}
+		case BorderReflect:
+			if i < 0 {
+				i = -i - 1
+			} else {
+				i = 2*n - i - 1
+			}
+		case BorderReflect101:
+			if n == 1 {
+				return 0
+			}
+			if i < 0 {
+				i = -i
+			} else {
+				i = 2*n - i - 2
+			}
+		case BorderWrap:
+			i %= n
+			if i < 0 {
+				i += n
+			}
+		default:
+			panic("sobel: borderIndex with " + b.String() + " border")
+		}
+	}
+	return i
+}
+
+// padGray returns a copy of img extended by r pixels on every side
+// according to the border policy. The copy keeps the coordinates of img,
+// so its bounds are img.Bounds().Inset(-r).
+func padGray(img *image.Gray, r int, b Border, value uint8) *image.Gray {
+	return PlaneGray(padPlane(GrayPlane(img), r, b, value))
+}
+
+// padPlane is padGray for planes of any pixel type
+func padPlane[T Pixel](img *Plane[T], r int, b Border, value T) *Plane[T] {
+	src := img.Rect
+	if src.Empty() {
+		//no pixels to extend, the result is as empty
+		return NewPlane[T](src)
+	}
+	padded := NewPlane[T](src.Inset(-r))
+	dst := padded.Rect
+	w, h := src.Dx(), src.Dy()
+	for y := dst.Min.Y; y < dst.Max.Y; y++ {
+		row := padded.Pix[padded.Offset(dst.Min.X, y):][:dst.Dx()]
+		if b == BorderConstant {
+			if y < src.Min.Y || y >= src.Max.Y {
+				for i := range row {
+					row[i] = value
+				}
+				continue
+			}
+			for i := 0; i < r; i++ {
+				row[i] = value
+				row[len(row)-1-i] = value
+			}
+			copy(row[r:], img.Pix[img.Offset(src.Min.X, y):][:w])
+			continue
+		}
+		sy := src.Min.Y + borderIndex(y-src.Min.Y, h, b)
+		srcRow := img.Pix[img.Offset(src.Min.X, sy):][:w]
+		copy(row[r:], srcRow)
+		for i := 0; i < r; i++ {
+			row[i] = srcRow[borderIndex(i-r, w, b)]
+			row[len(row)-1-i] = srcRow[borderIndex(w+r-1-i, w, b)]
+		}
+	}
+	return padded
+}
+
+// cropGray returns a sub-image of img without the frame of r pixels
+func cropGray(img *image.Gray, r int) *image.Gray {
+	return img.SubImage(img.Bounds().Inset(r)).(*image.Gray)
+}
+
+// clearFrame zeroes a frame of n pixels along the image edges
+func clearFrame(img *image.Gray, n int) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y) : img.PixOffset(b.Min.X, y)+b.Dx()]
+		if y < b.Min.Y+n || y >= b.Max.Y-n {
+			for i := range row {
+				row[i] = 0
+			}
+			continue
+		}
+		for i := 0; i < n && i < len(row); i++ {
+			row[i] = 0
+			row[len(row)-1-i] = 0
+		}
+	}
+}
+
+// withBorder runs filter, which computes only the pixels that have a
+// complete neighbourhood of radius r and leaves the rest 0, with the
+// border policy and workers of o.
+func withBorder(grayImg *image.Gray, r int, o *options, filter func(*image.Gray) *image.Gray) *image.Gray {
+	return PlaneGray(withBorderPlane(GrayPlane(grayImg), r, o, grayFilter(filter)))
+}
+
+// withBorderPlane is withBorder for planes of any pixel type, the result
+// may have another one. BorderConstant pads with the WithBorderValue
+// value as it is, not scaled to the pixel range.
+func withBorderPlane[T, U Pixel](img *Plane[T], r int, o *options, filter func(*Plane[T]) *Plane[U]) *Plane[U] {
+	if img.Rect.Empty() {
+		//nothing to pad or filter, the border policies can't map into
+		//zero rows or columns
+		if o.border == BorderCrop {
+			return NewPlane[U](img.Rect).SubPlane(img.Rect.Inset(r))
+		}
+		return NewPlane[U](img.Rect)
+	}
+	switch o.border {
+	case BorderNone:
+		return runTiled(img, r, o, filter)
+	case BorderCrop:
+		return runTiled(img, r, o, filter).SubPlane(img.Rect.Inset(r))
+	}
+	if !o.border.valid() {
+		panic("sobel: unknown border " + o.border.String())
+	}
+	//every pixel of img has a complete neighbourhood in the padded
+	//image, the frame of the result is the padding and is dropped
+	padded := padPlane(img, r, o.border, T(o.borderValue))
+	return runTiled(padded, r, o, filter).SubPlane(img.Rect)
+}
+
+// grayFilter adapts a filter of gray images to planes
+func grayFilter(filter func(*image.Gray) *image.Gray) func(*Plane[uint8]) *Plane[uint8] {
+	return func(p *Plane[uint8]) *Plane[uint8] {
+		return GrayPlane(filter(PlaneGray(p)))
+	}
+}
@@ -0,0 +1,161 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Canny returns the binary edge map (0 or 255) of the Canny detector:
+// the image is optionally smoothed (WithBlur, WithBoxBlur), its gradient
+// is computed with the kernel, backend, magnitude, border and workers of
+// opts, edges are thinned to local maxima along the gradient orientation and kept by
+// hysteresis: pixels above the high threshold and those above the low
+// one connected to them. Thresholds are magnitudes as computed, not
+// mapped to 0..255, they are set by WithThresholds or chosen from the
+// image by default (see WithAutoThresholds). It takes the kernel,
+// backend, magnitude, border, pre-smoothing, threshold, luma and workers
+// options.
+func Canny(img image.Image, opts ...Option) (*image.Gray, error) {
+	o := newOptions(opts)
+	if err := o.only("Canny", optKernel|optBackend|optMagnitude|optBorder|optBlur|optThresholds|optLuma|optWorkers); err != nil {
+		return nil, err
+	}
+	if err := o.validateCanny(); err != nil {
+		return nil, err
+	}
+	g, err := applyGradient(img, &o)
+	if err != nil {
+		return nil, err
+	}
+
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	mag := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := g.Offset(g.Rect.Min.X+x, g.Rect.Min.Y+y)
+			mag[y*w+x] = o.mag.norm(float64(g.Dx[i]), float64(g.Dy[i]))
+		}
+	}
+	low, high := o.low, o.high
+	if o.auto {
+		low, high = autoThresholds(mag, o.notEdges, o.lowRatio)
+	}
+	return hysteresis(g.Rect, suppressNonMaxima(g, mag), low, high), nil
+}
+
+func (o *options) validateCanny() error {
+	if !o.auto && !(o.low >= 0 && o.low <= o.high) {
+		return fmt.Errorf("sobel: invalid thresholds %v..%v", o.low, o.high)
+	}
+	if o.auto && !(o.notEdges > 0 && o.notEdges < 1 && o.lowRatio > 0 && o.lowRatio <= 1) {
+		return fmt.Errorf("sobel: invalid automatic thresholds %v, %v", o.notEdges, o.lowRatio)
+	}
+	return nil
+}
+
+// autoThresholds picks high so that the notEdges fraction of the pixels
+// is below it, the way MATLAB does it, from a histogram of the magnitudes
+func autoThresholds(mag []float64, notEdges, lowRatio float64) (low, high float64) {
+	const bins = 256
+	var max float64
+	for _, v := range mag {
+		max = math.Max(max, v)
+	}
+	if max == 0 {
+		return 1, 1 //no edges at all
+	}
+	var hist [bins]int
+	for _, v := range mag {
+		b := int(v / max * bins)
+		if b >= bins {
+			b = bins - 1
+		}
+		hist[b]++
+	}
+	limit := notEdges * float64(len(mag))
+	b, sum := 0, hist[0]
+	for float64(sum) < limit && b < bins-1 {
+		b++
+		sum += hist[b]
+	}
+	high = float64(b+1) * max / bins
+	return lowRatio * high, high
+}
+
+// tan(22.5°), the boundary between the horizontal and diagonal orientations
+var tan22 = math.Tan(math.Pi / 8)
+
+// suppressNonMaxima returns mag with only the pixels greater than their
+// neighbours along the gradient orientation, quantised to 0°, 45°, 90°
+// or 135°. Of two equal neighbours the first one is kept, so a step edge
+// stays one pixel wide.
+func suppressNonMaxima(g *Gradient, mag []float64) []float64 {
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	at := func(x, y int) float64 {
+		if x < 0 || y < 0 || x >= w || y >= h {
+			return 0
+		}
+		return mag[y*w+x]
+	}
+	thin := make([]float64, len(mag))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m := mag[y*w+x]
+			if m == 0 {
+				continue
+			}
+			dx, dy := g.At(g.Rect.Min.X+x, g.Rect.Min.Y+y)
+			ax, ay := math.Abs(float64(dx)), math.Abs(float64(dy))
+			//(sx, sy) is the step to the next pixel along the gradient
+			sx, sy := 1, 1
+			switch {
+			case ay <= ax*tan22:
+				sy = 0
+			case ax <= ay*tan22:
+				sx = 0
+			case (dx > 0) != (dy > 0):
+				sy = -1
+			}
+			if m > at(x-sx, y-sy) && m >= at(x+sx, y+sy) {
+				thin[y*w+x] = m
+			}
+		}
+	}
+	return thin
+}
+
+// hysteresis returns the pixels of thin above high and the ones above low
+// 8-connected to them, suppressed pixels (0) are never edges even with
+// zero thresholds
+func hysteresis(r image.Rectangle, thin []float64, low, high float64) *image.Gray {
+	edges := image.NewGray(r)
+	w, h := r.Dx(), r.Dy()
+	var stack []int
+	mark := func(x, y int) {
+		i := edges.PixOffset(r.Min.X+x, r.Min.Y+y)
+		if edges.Pix[i] == 0 {
+			edges.Pix[i] = 255
+			stack = append(stack, y*w+x)
+		}
+	}
+	for i, m := range thin {
+		if m < high || m == 0 {
+			continue
+		}
+		mark(i%w, i/w)
+		for len(stack) > 0 {
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := j%w, j/w
+			for ny := y - 1; ny <= y+1; ny++ {
+				for nx := x - 1; nx <= x+1; nx++ {
+					if nx >= 0 && ny >= 0 && nx < w && ny < h && thin[ny*w+nx] >= low && thin[ny*w+nx] > 0 {
+						mark(nx, ny)
+					}
+				}
+			}
+		}
+	}
+	return edges
+}
@@ -0,0 +1,114 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// ColorMode selects how ColorGradient combines the gradients of the R, G
+// and B channels
+type ColorMode int
+
+const (
+	//ColorDiZenzo takes the largest eigenvalue and its eigenvector of the
+	//structure tensor summed over the channels: the direction in which the
+	//colour changes the most
+	ColorDiZenzo ColorMode = iota
+	//ColorMaxChannel takes the gradient of the channel with the largest
+	//magnitude
+	ColorMaxChannel
+)
+
+func (m ColorMode) String() string {
+	switch m {
+	case ColorDiZenzo:
+		return "DiZenzo"
+	case ColorMaxChannel:
+		return "MaxChannel"
+	}
+	return fmt.Sprintf("ColorMode(%d)", int(m))
+}
+
+// ColorGradient computes the gradient of the R, G and B channels of img
+// (premultiplied, as returned by its color.RGBAModel) and combines them,
+// so edges between colours of the same luminance are kept. The result
+// has the usual Magnitude and Orientation; with ColorDiZenzo the
+// orientation is only defined up to 180° and is chosen to agree with the
+// sum of the channel gradients. It takes the options of ApplyGradient but
+// the luma one.
+func ColorGradient(img image.Image, mode ColorMode, opts ...Option) (*Gradient, error) {
+	if mode != ColorDiZenzo && mode != ColorMaxChannel {
+		return nil, fmt.Errorf("sobel: unknown color mode %v", mode)
+	}
+	o := newOptions(opts)
+	if err := o.only("ColorGradient", optGradient&^optLuma); err != nil {
+		return nil, err
+	}
+	planes := splitRGBA(img)
+	var grads [3]*Gradient
+	for i := range grads {
+		g, err := applyGradient(planes[i], &o)
+		if err != nil {
+			return nil, err
+		}
+		grads[i] = g
+	}
+
+	res := NewGradient(grads[0].Rect)
+	b := res.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var dx, dy [3]float64
+			for c, g := range grads {
+				i := g.Offset(x, y)
+				dx[c], dy[c] = float64(g.Dx[i]), float64(g.Dy[i])
+			}
+			var gx, gy float64
+			if mode == ColorDiZenzo {
+				gx, gy = diZenzo(dx, dy)
+			} else {
+				gx, gy = maxChannel(dx, dy)
+			}
+			i := res.Offset(x, y)
+			res.Dx[i], res.Dy[i] = float32(gx), float32(gy)
+		}
+	}
+	return res, nil
+}
+
+// diZenzo returns the gradient of the multichannel structure tensor:
+// sqrt of its largest eigenvalue in the direction of the eigenvector
+func diZenzo(dx, dy [3]float64) (gx, gy float64) {
+	var gxx, gyy, gxy, sx, sy float64
+	for c := range dx {
+		gxx += dx[c] * dx[c]
+		gyy += dy[c] * dy[c]
+		gxy += dx[c] * dy[c]
+		sx += dx[c]
+		sy += dy[c]
+	}
+	if gxx+gyy == 0 {
+		return 0, 0
+	}
+	d := gxx - gyy
+	lambda := (gxx + gyy + math.Sqrt(d*d+4*gxy*gxy)) / 2
+	theta := math.Atan2(2*gxy, d) / 2
+	m := math.Sqrt(lambda)
+	gx, gy = m*math.Cos(theta), m*math.Sin(theta)
+	if gx*sx+gy*sy < 0 {
+		gx, gy = -gx, -gy
+	}
+	return gx, gy
+}
+
+// maxChannel returns the gradient of the strongest channel
+func maxChannel(dx, dy [3]float64) (gx, gy float64) {
+	best := -1.0
+	for c := range dx {
+		if m := dx[c]*dx[c] + dy[c]*dy[c]; m > best {
+			best, gx, gy = m, dx[c], dy[c]
+		}
+	}
+	return gx, gy
+}
@@ -0,0 +1,271 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// ColorSpace selects the channels FilterChannels and FilterColor work on.
+// Every channel is an 8-bit plane, the order is the one of the name.
+type ColorSpace int
+
+const (
+	SpaceRGB   ColorSpace = iota //R, G, B
+	SpaceYCbCr                   //Y, Cb, Cr of JPEG (full range)
+	SpaceHSV                     //hue 0..360° as 0..255, saturation, value
+	SpaceLab                     //CIE L*a*b* of sRGB, D65: L 0..100 as 0..255, a and b + 128
+)
+
+func (s ColorSpace) String() string {
+	switch s {
+	case SpaceRGB:
+		return "RGB"
+	case SpaceYCbCr:
+		return "YCbCr"
+	case SpaceHSV:
+		return "HSV"
+	case SpaceLab:
+		return "Lab"
+	}
+	return fmt.Sprintf("ColorSpace(%d)", int(s))
+}
+
+func (s ColorSpace) valid() bool {
+	return s >= SpaceRGB && s <= SpaceLab
+}
+
+// FilterChannels converts img to space and runs the filter flt, with the
+// options of Apply, on each channel selected by WithChannels (all by
+// default). The results are in channel order, nil for channels that
+// were not selected. It takes the options of Apply but the luma one, and
+// the channel ones; kernel options override flt.
+func FilterChannels(img image.Image, flt FilterType, space ColorSpace, opts ...Option) ([3]*image.Gray, error) {
+	planes, _, err := spacePlanes(img, space)
+	if err != nil {
+		return [3]*image.Gray{}, err
+	}
+	return filterPlanes("FilterChannels", planes, flt, opts)
+}
+
+// FilterColor is FilterChannels returning an image: the filtered
+// channels replace the ones of img, which are converted back to RGB with
+// the alpha of img. With SpaceRGB and all channels it is the per channel
+// edge image, with image filters such as Sharpen it filters just the
+// selected channels, e.g. L of SpaceLab. It takes the options of
+// FilterChannels.
+func FilterColor(img image.Image, flt FilterType, space ColorSpace, opts ...Option) (*image.RGBA, error) {
+	planes, alpha, err := spacePlanes(img, space)
+	if err != nil {
+		return nil, err
+	}
+	filtered, err := filterPlanes("FilterColor", planes, flt, opts)
+	if err != nil {
+		return nil, err
+	}
+	var b image.Rectangle
+	for _, p := range filtered {
+		if p != nil {
+			b = p.Bounds()
+		}
+	}
+	for i, p := range filtered {
+		if p == nil {
+			filtered[i] = planes[i].SubImage(b).(*image.Gray)
+		}
+	}
+	return mergeSpace(filtered, alpha.SubImage(b).(*image.Gray), space), nil
+}
+
+func filterPlanes(fn string, planes [3]*image.Gray, flt FilterType, opts []Option) (filtered [3]*image.Gray, err error) {
+	o := newOptions(opts)
+	if err := o.only(fn, optFilter&^optLuma|optChannels); err != nil {
+		return filtered, err
+	}
+	if o.set&optKernel == 0 {
+		o.kernel = flt
+	}
+	if o.channels >= 1<<3 {
+		return filtered, fmt.Errorf("sobel: invalid channels %b", o.channels)
+	}
+	for i, p := range planes {
+		if o.channels != 0 && o.channels&(1<<i) == 0 {
+			continue
+		}
+		if filtered[i], _, err = apply(p, &o); err != nil {
+			return [3]*image.Gray{}, err
+		}
+	}
+	return filtered, nil
+}
+
+// spacePlanes returns the channels of img in space and its alpha
+func spacePlanes(img image.Image, space ColorSpace) (planes [3]*image.Gray, alpha *image.Gray, err error) {
+	if !space.valid() {
+		return planes, nil, fmt.Errorf("sobel: unknown color space %v", space)
+	}
+	b := img.Bounds()
+	for i := range planes {
+		planes[i] = image.NewGray(b)
+	}
+	alpha = image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			i := alpha.PixOffset(x, y)
+			planes[0].Pix[i], planes[1].Pix[i], planes[2].Pix[i] = toSpace(space, c.R, c.G, c.B)
+			alpha.Pix[i] = c.A
+		}
+	}
+	return planes, alpha, nil
+}
+
+// mergeSpace converts the channels of space with the alpha to an image
+func mergeSpace(planes [3]*image.Gray, alpha *image.Gray, space ColorSpace) *image.RGBA {
+	b := planes[0].Bounds()
+	res := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl := fromSpace(space, planes[0].GrayAt(x, y).Y, planes[1].GrayAt(x, y).Y, planes[2].GrayAt(x, y).Y)
+			res.Set(x, y, color.NRGBA{R: r, G: g, B: bl, A: alpha.GrayAt(x, y).Y})
+		}
+	}
+	return res
+}
+
+func toSpace(space ColorSpace, r, g, b uint8) (c0, c1, c2 uint8) {
+	switch space {
+	case SpaceYCbCr:
+		return color.RGBToYCbCr(r, g, b)
+	case SpaceHSV:
+		return rgbToHSV(r, g, b)
+	case SpaceLab:
+		return rgbToLab(r, g, b)
+	}
+	return r, g, b
+}
+
+func fromSpace(space ColorSpace, c0, c1, c2 uint8) (r, g, b uint8) {
+	switch space {
+	case SpaceYCbCr:
+		return color.YCbCrToRGB(c0, c1, c2)
+	case SpaceHSV:
+		return hsvToRGB(c0, c1, c2)
+	case SpaceLab:
+		return labToRGB(c0, c1, c2)
+	}
+	return c0, c1, c2
+}
+
+// clamp8 rounds v and clamps it to 0..255
+func clamp8(v float64) uint8 {
+	return mapPixel(v, 1, 0)
+}
+
+func rgbToHSV(r, g, b uint8) (h, s, v uint8) {
+	max, min := r, r
+	for _, c := range []uint8{g, b} {
+		if c > max {
+			max = c
+		}
+		if c < min {
+			min = c
+		}
+	}
+	if max == min {
+		return 0, 0, max
+	}
+	d := float64(max) - float64(min)
+	var deg float64
+	switch max {
+	case r:
+		deg = 60 * (float64(g) - float64(b)) / d
+	case g:
+		deg = 60 * (2 + (float64(b)-float64(r))/d)
+	default:
+		deg = 60 * (4 + (float64(r)-float64(g))/d)
+	}
+	if deg < 0 {
+		deg += 360
+	}
+	return uint8(int(deg*256/360+0.5) % 256), clamp8(d * 255 / float64(max)), max
+}
+
+func hsvToRGB(h, s, v uint8) (r, g, b uint8) {
+	deg := float64(h) * 360 / 256
+	sv, vv := float64(s)/255, float64(v)
+	c := vv * sv
+	x := c * (1 - math.Abs(math.Mod(deg/60, 2)-1))
+	var rf, gf, bf float64
+	switch {
+	case deg < 60:
+		rf, gf = c, x
+	case deg < 120:
+		rf, gf = x, c
+	case deg < 180:
+		gf, bf = c, x
+	case deg < 240:
+		gf, bf = x, c
+	case deg < 300:
+		rf, bf = x, c
+	default:
+		rf, bf = c, x
+	}
+	m := vv - c
+	return clamp8(rf + m), clamp8(gf + m), clamp8(bf + m)
+}
+
+// D65 white of sRGB
+const labXn, labYn, labZn = 0.95047, 1.0, 1.08883
+
+func srgbToLinear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) uint8 {
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return clamp8(v * 255)
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return t*24389/27/116 + 16.0/116
+}
+
+func labFInv(t float64) float64 {
+	if t3 := t * t * t; t3 > 216.0/24389 {
+		return t3
+	}
+	return (116*t - 16) * 27 / 24389
+}
+
+func rgbToLab(r, g, b uint8) (l, a, bb uint8) {
+	rl, gl, bl := srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)
+	x := 0.4124564*rl + 0.3575761*gl + 0.1804375*bl
+	y := 0.2126729*rl + 0.7151522*gl + 0.0721750*bl
+	z := 0.0193339*rl + 0.1191920*gl + 0.9503041*bl
+	fx, fy, fz := labF(x/labXn), labF(y/labYn), labF(z/labZn)
+	return clamp8((116*fy - 16) * 2.55), clamp8(500*(fx-fy) + 128), clamp8(200*(fy-fz) + 128)
+}
+
+func labToRGB(l, a, bb uint8) (r, g, b uint8) {
+	fy := (float64(l)/2.55 + 16) / 116
+	fx := fy + (float64(a)-128)/500
+	fz := fy - (float64(bb)-128)/200
+	x, y, z := labFInv(fx)*labXn, labFInv(fy)*labYn, labFInv(fz)*labZn
+	rl := 3.2404542*x - 1.5371385*y - 0.4985314*z
+	gl := -0.9692660*x + 1.8760108*y + 0.0415560*z
+	bl := 0.0556434*x - 0.2040259*y + 1.0572252*z
+	return linearToSRGB(rl), linearToSRGB(gl), linearToSRGB(bl)
+}
@@ -0,0 +1,114 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+)
+
+// Compass operators convolve the image with 8 rotations of one 3x3 mask
+// and take the strongest response. Direction d means the mask rotated by
+// d*45° clockwise from the north one, which responds to images brighter
+// at the top.
+var (
+	kirschMasks = compassMasks([]float64{
+		5, 5, 5,
+		-3, 0, -3,
+		-3, -3, -3,
+	})
+	robinsonMasks = compassMasks([]float64{
+		1, 2, 1,
+		0, 0, 0,
+		-1, -2, -1,
+	})
+)
+
+// compassRing lists indices of the 3x3 border clockwise from the top left
+var compassRing = [8]int{0, 1, 2, 5, 8, 7, 6, 3}
+
+// compassMasks returns north mask rotated 8 times by 45° clockwise
+func compassMasks(north []float64) (masks [8]*Kernel) {
+	for d := range masks {
+		w := make([]float64, 9)
+		w[4] = north[4]
+		for i, idx := range compassRing {
+			w[compassRing[(i+d)%8]] = north[idx]
+		}
+		masks[d] = &Kernel{Size: 3, Weights: w}
+	}
+	return masks
+}
+
+// compass returns the masks of compass filters, nil for the others
+func (flt FilterType) compass() []*Kernel {
+	switch flt {
+	case Kirsch:
+		return kirschMasks[:]
+	case Robinson:
+		return robinsonMasks[:]
+	}
+	return nil
+}
+
+// Compass runs a compass filter (Kirsch or Robinson) and returns the
+// strongest response clipped to 255 and the index (0..7) of the mask
+// it came from. It takes the border and workers options.
+func Compass(grayImg *image.Gray, flt FilterType, opts ...Option) (magnitude, direction *image.Gray, err error) {
+	masks := flt.compass()
+	if masks == nil {
+		return nil, nil, fmt.Errorf("sobel: %v is not a compass filter", flt)
+	}
+	o := newOptions(opts)
+	if err := o.only("Compass", optBorder|optWorkers); err != nil {
+		return nil, nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, nil, err
+	}
+	magnitude, direction = compassBorder(grayImg, masks, &o)
+	return magnitude, direction, nil
+}
+
+func compassBorder(grayImg *image.Gray, masks []*Kernel, o *options) (magnitude, direction *image.Gray) {
+	//the filter computes the pixels of grayImg bounds only (padded images
+	//keep the coordinates, bands don't overlap), so it can fill direction
+	direction = image.NewGray(grayImg.Bounds())
+	magnitude = withBorder(grayImg, 1, o, func(img *image.Gray) *image.Gray {
+		return filterGrayCompass(img, masks, direction)
+	})
+	if o.border == BorderCrop {
+		direction = cropGray(direction, 1)
+	}
+	return magnitude, direction
+}
+
+// filterGrayCompass returns the strongest mask response of every pixel
+// that has a complete neighbourhood and writes the mask index to direction
+func filterGrayCompass(grayImg *image.Gray, masks []*Kernel, direction *image.Gray) (filtered *image.Gray) {
+	max := grayImg.Bounds().Max
+	min := grayImg.Bounds().Min
+	filtered = image.NewGray(grayImg.Bounds())
+	convs := make([]*conv, len(masks))
+	for i, m := range masks {
+		convs[i] = m.prepare(grayImg.Stride)
+	}
+
+	for y := min.Y + 1; y < max.Y-1; y++ {
+		for x := min.X + 1; x < max.X-1; x++ {
+			i := grayImg.PixOffset(x, y)
+			best, dir := convs[0].responseInt(grayImg.Pix, i), 0
+			for d := 1; d < len(convs); d++ {
+				if v := convs[d].responseInt(grayImg.Pix, i); v > best {
+					best, dir = v, d
+				}
+			}
+			if best > 255 {
+				best = 255
+			} else if best < 0 {
+				best = 0
+			}
+			filtered.Pix[filtered.PixOffset(x, y)] = uint8(best)
+			direction.Pix[direction.PixOffset(x, y)] = uint8(dir)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,365 @@
+package sobel
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var conformanceDir = flag.String("conformance", "", "directory the diff images of conformance and golden failures are written to")
+
+// tolerance is what two backends may differ by for the same filter
+type tolerance struct {
+	maxErr     int     //per pixel, in output levels (gradients in response units)
+	mismatches float64 //fraction of the pixels that may differ at all
+}
+
+// tolerances of the implementations that don't round magnitudes to the
+// nearest level, there are none: every backend and FilterGray* function
+// has the same integer kernel sums, and the L2 of go (FloorSqrtFast), math
+// (math.Sqrt), asm (float32 vector lanes) and the C loop rounds to
+// nearest. Gradients, other norms and mappings are computed from the sums
+// by common code. A pair of implementations may differ by the sum of their
+// tolerances.
+var tolerances = map[string]tolerance{}
+
+// pairTolerance returns what a and b may differ by
+func pairTolerance(a, b string) tolerance {
+	ta, tb := tolerances[a], tolerances[b]
+	return tolerance{maxErr: ta.maxErr + tb.maxErr, mismatches: math.Min(1, ta.mismatches+tb.mismatches)}
+}
+
+// conformance is the comparison of two outputs of the same filter
+type conformance struct {
+	pixels     int
+	maxErr     int
+	mismatches int
+	diff       *image.Gray //|a - b| ×32, so that an error of 1 shows
+}
+
+func (c conformance) within(tol tolerance) bool {
+	return c.maxErr <= tol.maxErr && float64(c.mismatches) <= tol.mismatches*float64(c.pixels)
+}
+
+func (c conformance) String() string {
+	return fmt.Sprintf("max error %d, %d of %d pixels differ", c.maxErr, c.mismatches, c.pixels)
+}
+
+// compareGray compares images of the same bounds
+func compareGray(a, b *image.Gray) conformance {
+	r := a.Bounds()
+	c := conformance{pixels: r.Dx() * r.Dy(), diff: image.NewGray(r)}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			d := int(a.GrayAt(x, y).Y) - int(b.GrayAt(x, y).Y)
+			if d < 0 {
+				d = -d
+			}
+			if d == 0 {
+				continue
+			}
+			c.mismatches++
+			if d > c.maxErr {
+				c.maxErr = d
+			}
+			c.diff.Pix[c.diff.PixOffset(x, y)] = uint8(math.Min(255, float64(d*32)))
+		}
+	}
+	return c
+}
+
+// compareGradient is compareGray for X and Y responses, the diff image
+// is the larger of both differences
+func compareGradient(a, b *Gradient) conformance {
+	r := a.Rect
+	c := conformance{pixels: r.Dx() * r.Dy(), diff: image.NewGray(r)}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			dx, dy := a.At(x, y)
+			ex, ey := b.At(x, y)
+			d := int(math.Ceil(math.Max(math.Abs(float64(dx-ex)), math.Abs(float64(dy-ey)))))
+			if d == 0 {
+				continue
+			}
+			c.mismatches++
+			if d > c.maxErr {
+				c.maxErr = d
+			}
+			c.diff.Pix[c.diff.PixOffset(x, y)] = uint8(math.Min(255, float64(d*32)))
+		}
+	}
+	return c
+}
+
+// conformanceCase is an input of the corpus
+type conformanceCase struct {
+	name string
+	img  *image.Gray
+}
+
+// conformanceCorpus returns synthetic images of awkward sizes, from empty
+// ones, 1x1 and 3x3 to very wide and very tall ones, and crops of the test
+// image
+func (s *SobelTS) conformanceCorpus() []conformanceCase {
+	var corpus []conformanceCase
+	add := func(name string, r image.Rectangle, pixel func(x, y int) uint8) {
+		img := image.NewGray(r)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				img.Pix[img.PixOffset(x, y)] = pixel(x, y)
+			}
+		}
+		corpus = append(corpus, conformanceCase{fmt.Sprintf("%s_%dx%d", name, r.Dx(), r.Dy()), img})
+	}
+	rnd := rand.New(rand.NewSource(24))
+	sizes := []image.Rectangle{
+		image.Rect(0, 0, 1, 1),
+		image.Rect(0, 0, 2, 5),
+		image.Rect(0, 0, 3, 3),
+		image.Rect(0, 0, 4, 3),
+		image.Rect(5, 7, 12, 12),
+		image.Rect(-3, 2, 30, 19),
+		image.Rect(0, 0, 65, 33),
+		image.Rect(0, 0, 1031, 3),
+		image.Rect(0, 0, 2000, 7),
+		image.Rect(0, 0, 3, 1031),
+		image.Rect(0, 0, 6, 1500),
+	}
+	for _, r := range sizes {
+		add("noise", r, func(x, y int) uint8 { return uint8(rnd.Intn(256)) })
+		add("lownoise", r, func(x, y int) uint8 { return uint8(rnd.Intn(32)) })
+		add("checker", r, func(x, y int) uint8 { return uint8((x + y) & 1 * 255) })
+		add("ramp", r, func(x, y int) uint8 { return uint8(3*x + 5*y) })
+		add("disc", r, func(x, y int) uint8 {
+			if (x-r.Min.X)*(x-r.Min.X)+(y-r.Min.Y)*(y-r.Min.Y) < r.Dx()*r.Dy()/4 {
+				return 200
+			}
+			return 20
+		})
+	}
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, 0, 5),
+		image.Rect(3, 2, 10, 2),
+	} {
+		corpus = append(corpus, conformanceCase{fmt.Sprintf("empty_%dx%d", r.Dx(), r.Dy()), image.NewGray(r)})
+	}
+	b := s.img.Bounds()
+	outside := s.img.SubImage(image.Rect(b.Max.X+5, b.Min.Y, b.Max.X+9, b.Max.Y)).(*image.Gray)
+	corpus = append(corpus, conformanceCase{"test_outside", outside})
+	for _, r := range []image.Rectangle{
+		b,
+		image.Rect(b.Min.X+17, b.Min.Y+9, b.Min.X+17+61, b.Min.Y+9+47),
+		image.Rect(b.Min.X, b.Min.Y+100, b.Max.X, b.Min.Y+103),
+		image.Rect(b.Min.X+200, b.Min.Y, b.Min.X+203, b.Max.Y),
+	} {
+		crop := image.NewGray(r.Intersect(b))
+		for y := crop.Rect.Min.Y; y < crop.Rect.Max.Y; y++ {
+			copy(crop.Pix[crop.PixOffset(crop.Rect.Min.X, y):], s.img.Pix[s.img.PixOffset(crop.Rect.Min.X, y):][:crop.Rect.Dx()])
+		}
+		corpus = append(corpus, conformanceCase{fmt.Sprintf("test_%dx%d", crop.Rect.Dx(), crop.Rect.Dy()), crop})
+	}
+	return corpus
+}
+
+// conformanceFilters are the option sets every backend that has the
+// kernel runs
+func conformanceFilters() map[string][]Option {
+	filters := map[string][]Option{
+		"L1":          {WithMagnitude(MagnitudeL1)},
+		"LInf":        {WithMagnitude(MagnitudeLInf)},
+		"Squared":     {WithMagnitude(MagnitudeSquared), WithScale(0.01)},
+		"Normalize":   {WithMapping(MappingNormalize)},
+		"Range":       {WithRange(10, 300)},
+		"Reflect":     {WithBorder(BorderReflect101), WithWorkers(3)},
+		"Crop":        {WithBorder(BorderCrop)},
+		"Convolution": {WithKernel(Sobel5), WithConvolution(), WithRange(-255, 255)},
+	}
+	for flt := Sobel; flt <= Robinson; flt++ {
+		filters[flt.String()] = []Option{WithKernel(flt)}
+	}
+	return filters
+}
+
+// emptyFilters are the filters empty images of the corpus run besides
+// conformanceFilters, one per border
+func emptyFilters() map[string][]Option {
+	filters := map[string][]Option{}
+	for border := BorderNone; border <= BorderWrap; border++ {
+		filters["Border"+border.String()] = []Option{WithBorder(border), WithBorderValue(9)}
+	}
+	return filters
+}
+
+// sobelFuncs are the FilterGray* functions of 3x3 Sobel, they run the
+// filters of conformanceFilters that take only border and worker options
+func sobelFuncs() map[string]func(*image.Gray, ...Option) *image.Gray {
+	funcs := map[string]func(*image.Gray, ...Option) *image.Gray{
+		"FilterGray": func(img *image.Gray, opts ...Option) *image.Gray {
+			return FilterGray(img, Sobel, opts...)
+		},
+		"FilterGrayFast": func(img *image.Gray, opts ...Option) *image.Gray {
+			return FilterGrayFast(img, Sobel, opts...)
+		},
+	}
+	for name, filter := range sobelFilters {
+		funcs[name] = filter
+	}
+	return funcs
+}
+
+// conformanceResult sums up the comparisons of a pair
+type conformanceResult struct {
+	runs       int
+	pixels     int
+	mismatches int
+	maxErr     int
+	where      string //the run with the largest error
+}
+
+func (s *SobelTS) Test_Conformance(t *testing.T) {
+	//the comparison itself
+	a := randomGray(image.Rect(1, 2, 9, 7), 25)
+	b := image.NewGray(a.Rect)
+	copy(b.Pix, a.Pix)
+	if c := compareGray(a, b); c.maxErr != 0 || c.mismatches != 0 || c.pixels != 40 {
+		t.Fatalf("same images: %v", c)
+	}
+	b.SetGray(4, 5, color.Gray{a.GrayAt(4, 5).Y + 3})
+	if c := compareGray(a, b); c.maxErr != 3 || c.mismatches != 1 || c.diff.GrayAt(4, 5).Y != 96 || c.within(tolerance{maxErr: 2, mismatches: 1}) {
+		t.Fatalf("one pixel 3 off: %v", c)
+	}
+
+	backends := testBackends()
+	sort.Strings(backends)
+	results := map[[2]string]*conformanceResult{}
+	check := func(a, b, where string, c conformance) {
+		key := [2]string{a, b}
+		res := results[key]
+		if res == nil {
+			res = &conformanceResult{}
+			results[key] = res
+		}
+		res.runs++
+		res.pixels += c.pixels
+		res.mismatches += c.mismatches
+		if c.maxErr > res.maxErr {
+			res.maxErr, res.where = c.maxErr, where
+		}
+		if tol := pairTolerance(a, b); !c.within(tol) {
+			t.Errorf("%s vs %s, %s: %v, allowed %d and %g", a, b, where, c, tol.maxErr, tol.mismatches)
+			writeDiff(t, a+"_"+b+"_"+where, c.diff)
+		}
+	}
+
+	funcs := sobelFuncs()
+	all := append([]string{}, backends...)
+	for name := range funcs {
+		all = append(all, name)
+	}
+	sort.Strings(all)
+
+	filters, empty := conformanceFilters(), emptyFilters()
+	for name, opts := range empty {
+		filters[name] = opts
+	}
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, tc := range s.conformanceCorpus() {
+		for _, name := range names {
+			if _, ok := empty[name]; ok && !tc.img.Rect.Empty() {
+				continue
+			}
+			opts := filters[name]
+			outputs := map[string]*image.Gray{}
+			gradients := map[string]*Gradient{}
+			impls := backends
+			if _, ok := empty[name]; ok || name == "Sobel" || name == "Reflect" || name == "Crop" {
+				impls = all
+				for fname, filter := range funcs {
+					outputs[fname] = filter(tc.img, opts...)
+				}
+			}
+			for _, backend := range backends {
+				bopts := append(opts[:len(opts):len(opts)], WithBackend(backend))
+				out, err := Apply(tc.img, bopts...)
+				if errors.Is(err, ErrUnsupported) {
+					continue //the backend doesn't have the filter
+				} else if err != nil {
+					t.Fatalf("%s %s %s: %v", backend, tc.name, name, err)
+				}
+				outputs[backend] = out
+				if g, err := ApplyGradient(tc.img, bopts...); err == nil {
+					gradients[backend] = g
+				}
+			}
+			if tc.img.Rect.Empty() {
+				for impl, out := range outputs {
+					if !out.Bounds().Empty() {
+						t.Errorf("%s %s %s: bounds %v of an empty image", impl, tc.name, name, out.Bounds())
+					}
+				}
+			}
+			for i, a := range impls {
+				for _, b := range impls[i+1:] {
+					where := tc.name + "_" + name
+					if outputs[a] != nil && outputs[b] != nil && outputs[a].Bounds() != outputs[b].Bounds() {
+						t.Errorf("%s vs %s, %s: bounds %v and %v", a, b, where, outputs[a].Bounds(), outputs[b].Bounds())
+						continue
+					}
+					if outputs[a] != nil && outputs[b] != nil {
+						check(a, b, where, compareGray(outputs[a], outputs[b]))
+					}
+					if gradients[a] != nil && gradients[b] != nil {
+						check(a, b, where+"_gradient", compareGradient(gradients[a], gradients[b]))
+					}
+				}
+			}
+		}
+	}
+
+	keys := make([][2]string, 0, len(results))
+	for key := range results {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		res := results[key]
+		worst := ""
+		if res.where != "" {
+			worst = ", worst " + res.where
+		}
+		t.Logf("%s vs %s: %d runs, max error %d, %d of %d pixels differ%s", key[0], key[1], res.runs, res.maxErr, res.mismatches, res.pixels, worst)
+	}
+}
+
+// writeDiff saves a diff image to the -conformance directory, if any
+func writeDiff(t *testing.T, name string, diff *image.Gray) {
+	if *conformanceDir == "" {
+		return
+	}
+	name = strings.NewReplacer("/", "_", " ", "_").Replace(name)
+	f, err := os.Create(filepath.Join(*conformanceDir, name+".png"))
+	if err != nil {
+		t.Errorf("diff image: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := png.Encode(f, diff); err != nil {
+		t.Errorf("diff image: %v", err)
+	}
+}
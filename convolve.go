@@ -0,0 +1,221 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+var (
+	//emboss lights the image from the top left, it keeps the brightness
+	embossKernel = &Kernel{Size: 3, Weights: []float64{
+		-2, -1, 0,
+		-1, 1, 1,
+		0, 1, 2,
+	}}
+)
+
+// Convolve returns the response of k rounded and clamped to 0..255, as an
+// image filter rather than an edge detector. WithScale and WithRange map
+// the signed response before clamping, e.g. WithRange(-255, 255) keeps
+// negative responses. Gray images give *image.Gray, the others are
+// filtered per R, G and B channel and give *image.RGBA with the alpha of
+// img. It takes the border, mapping, pre-smoothing and workers options.
+func Convolve(img image.Image, k *Kernel, opts ...Option) (image.Image, error) {
+	return convolve("Convolve", img, k, opts)
+}
+
+// SharpenImage sharpens img with the Sharpen kernel, see Convolve
+func SharpenImage(img image.Image, opts ...Option) (image.Image, error) {
+	return convolve("SharpenImage", img, sharpenX, opts)
+}
+
+// Emboss returns img lit from the top left, see Convolve
+func Emboss(img image.Image, opts ...Option) (image.Image, error) {
+	return convolve("Emboss", img, embossKernel, opts)
+}
+
+// convolve is Convolve for fn
+func convolve(fn string, img image.Image, k *Kernel, opts []Option) (image.Image, error) {
+	o := newOptions(opts)
+	if err := o.only(fn, optBorder|optMapping|optBlur|optWorkers); err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return nil, fmt.Errorf("sobel: nil kernel")
+	}
+	o.kx, o.conv = k, true
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return filterImage(img, &o, func(grayImg *image.Gray) *image.Gray {
+		return convolveBorder(grayImg, k, &o)
+	}), nil
+}
+
+// UnsharpMask sharpens img by adding amount times its difference from
+// the image blurred by a Gaussian of sigma radius: 1 and 1 are a common
+// start, larger radii sharpen coarser details. Gray and other images are
+// handled as in Convolve, it takes the border, pre-smoothing and workers
+// options.
+func UnsharpMask(img image.Image, amount, radius float64, opts ...Option) (image.Image, error) {
+	if !(amount >= 0) || math.IsInf(amount, 0) {
+		return nil, fmt.Errorf("sobel: invalid unsharp mask amount %v", amount)
+	}
+	if !(radius > 0) || math.IsInf(radius, 0) {
+		return nil, fmt.Errorf("sobel: invalid unsharp mask radius %v", radius)
+	}
+	o := newOptions(opts)
+	if err := o.only("UnsharpMask", optBorder|optBlur|optWorkers); err != nil {
+		return nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	g := gaussianKernel(radius, 0)
+	return filterImage(img, &o, func(grayImg *image.Gray) *image.Gray {
+		return withBorder(grayImg, g.Radius(), &o, func(img *image.Gray) *image.Gray {
+			b := img.Bounds()
+			filtered := image.NewGray(b)
+			r := g.Radius()
+			kernelRows(img, g, nil, func(y int, blurred, _ []float64) {
+				src := img.Pix[img.PixOffset(b.Min.X, y):]
+				dst := filtered.Pix[filtered.PixOffset(b.Min.X, y):]
+				for x := r; x < len(blurred)-r; x++ {
+					p := float64(src[x])
+					dst[x] = mapPixel(p+amount*(p-blurred[x]), 1, 0)
+				}
+			})
+			return filtered
+		})
+	}), nil
+}
+
+// Image returns the response as an image: by default negative responses
+// are 0 and the ones above 255 are 255, WithRange or WithScale map them
+// first. It takes the mapping options, MappingNormalize excepted.
+func (r *Response) Image(opts ...Option) (*image.Gray, error) {
+	o := newOptions(opts)
+	if err := o.only("Response.Image", optMapping); err != nil {
+		return nil, err
+	}
+	if err := o.validateMagnitude(); err != nil {
+		return nil, err
+	}
+	if o.mapping == MappingNormalize {
+		return nil, fmt.Errorf("sobel: responses can't be normalized")
+	}
+	scale, offset := o.linear(0)
+	res := image.NewGray(r.Rect)
+	for y := r.Rect.Min.Y; y < r.Rect.Max.Y; y++ {
+		i := r.Offset(r.Rect.Min.X, y)
+		p := res.PixOffset(r.Rect.Min.X, y)
+		for x := 0; x < r.Rect.Dx(); x++ {
+			res.Pix[p+x] = mapPixel(float64(r.Pix[i+x]), scale, offset)
+		}
+	}
+	return res, nil
+}
+
+// convolution reports if Apply returns the signed response of the X
+// kernel instead of a magnitude. Sharpen is always run this way.
+func (o *options) convolution() bool {
+	return o.conv || !o.custom() && o.kernel == Sharpen
+}
+
+func (o *options) validateConvolution() error {
+	if !o.convolution() {
+		return nil
+	}
+	if o.custom() && o.kx == nil || !o.custom() && o.kernel.compass() != nil {
+		return fmt.Errorf("sobel: convolution needs an X kernel")
+	}
+	if o.mapping == MappingNormalize {
+		return fmt.Errorf("sobel: convolution can't be normalized")
+	}
+	return nil
+}
+
+// convolveBorder runs the X kernel k in convolution mode
+func convolveBorder(grayImg *image.Gray, k *Kernel, o *options) *image.Gray {
+	scale, offset := o.linear(0)
+	return withBorder(grayImg, k.Radius(), o, func(img *image.Gray) *image.Gray {
+		return filterGrayConv(img, k, scale, offset)
+	})
+}
+
+// filterGrayConv maps the response of k for every pixel that has a
+// complete neighbourhood, the rest is left black
+func filterGrayConv(grayImg *image.Gray, k *Kernel, scale, offset float64) *image.Gray {
+	b := grayImg.Bounds()
+	filtered := image.NewGray(b)
+	r := k.Radius()
+	kernelRows(grayImg, k, nil, func(y int, rx, _ []float64) {
+		dst := filtered.Pix[filtered.PixOffset(b.Min.X, y):]
+		for x := r; x < len(rx)-r; x++ {
+			dst[x] = mapPixel(rx[x], scale, offset)
+		}
+	})
+	return filtered
+}
+
+// filterImage runs filter on img if it is gray, otherwise on its
+// premultiplied R, G and B channels, and recombines them with the alpha
+func filterImage(img image.Image, o *options, filter func(*image.Gray) *image.Gray) image.Image {
+	if grayImg, ok := img.(*image.Gray); ok {
+		return filter(o.smooth(grayImg))
+	}
+	planes := splitRGBA(img)
+	for i := range planes[:3] {
+		planes[i] = filter(o.smooth(planes[i]))
+	}
+	alpha := planes[3]
+	if o.border == BorderCrop {
+		alpha = alpha.SubImage(planes[0].Bounds()).(*image.Gray)
+	}
+	return mergeRGBA(planes[0], planes[1], planes[2], alpha)
+}
+
+// splitRGBA returns the premultiplied R, G, B and alpha channels of img
+func splitRGBA(img image.Image) (planes [4]*image.Gray) {
+	b := img.Bounds()
+	for i := range planes {
+		planes[i] = image.NewGray(b)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			i := planes[0].PixOffset(x, y)
+			planes[0].Pix[i] = c.R
+			planes[1].Pix[i] = c.G
+			planes[2].Pix[i] = c.B
+			planes[3].Pix[i] = c.A
+		}
+	}
+	return planes
+}
+
+// mergeRGBA combines premultiplied channels of the same bounds, the
+// colour channels are clamped to alpha to stay valid
+func mergeRGBA(r, g, b, a *image.Gray) *image.RGBA {
+	bounds := r.Bounds()
+	res := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			alpha := a.GrayAt(x, y).Y
+			c := color.RGBA{R: r.GrayAt(x, y).Y, G: g.GrayAt(x, y).Y, B: b.GrayAt(x, y).Y, A: alpha}
+			if c.R > alpha {
+				c.R = alpha
+			}
+			if c.G > alpha {
+				c.G = alpha
+			}
+			if c.B > alpha {
+				c.B = alpha
+			}
+			res.SetRGBA(x, y, c)
+		}
+	}
+	return res
+}
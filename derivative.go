@@ -0,0 +1,55 @@
+package sobel
+
+import "fmt"
+
+// derivativeWeights returns the 1D Sobel factor of the size for the
+// derivative order: binomial smoothing [1 1]*...*[1 1] convolved order
+// times with the difference [-1 1], the way OpenCV builds it
+func derivativeWeights(size, order int) []float64 {
+	w := []float64{1}
+	for i := 0; i < size-1; i++ {
+		next := make([]float64, len(w)+1)
+		for j, v := range w {
+			if i < size-1-order {
+				next[j] += v //[1 1]
+			} else {
+				next[j] -= v //[-1 1]
+			}
+			next[j+1] += v
+		}
+		w = next
+	}
+	return w
+}
+
+// SobelKernel returns the size x size Sobel kernel of the derivative
+// d^(dx+dy) / dx^dx dy^dy. Size is 3, 5 or 7, the orders are up to 2,
+// e.g. SobelKernel(3, 1, 0) is the classic Sobel X kernel.
+func SobelKernel(size, dx, dy int) (*Kernel, error) {
+	if size != 3 && size != 5 && size != 7 {
+		return nil, fmt.Errorf("sobel: aperture %d is not 3, 5 or 7", size)
+	}
+	if dx < 0 || dy < 0 || dx > 2 || dy > 2 || dx+dy == 0 {
+		return nil, fmt.Errorf("sobel: invalid derivative order dx=%d dy=%d", dx, dy)
+	}
+	return NewSeparableKernel(derivativeWeights(size, dx), derivativeWeights(size, dy))
+}
+
+func mustSobelKernel(size, dx, dy int) *Kernel {
+	k, err := SobelKernel(size, dx, dy)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+// sobelKernels returns X and Y kernels of the derivative order
+func sobelKernels(size, order int) (kx, ky *Kernel, err error) {
+	if kx, err = SobelKernel(size, order, 0); err != nil {
+		return nil, nil, err
+	}
+	if ky, err = SobelKernel(size, 0, order); err != nil {
+		return nil, nil, err
+	}
+	return kx, ky, nil
+}
@@ -1,2 +1,4 @@
+//go:build cgo && libsimd
+
 //To use g++ insted of gcc
 //https://github.com/golang/go/issues/18460
\ No newline at end of file
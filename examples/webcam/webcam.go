@@ -59,6 +59,7 @@ func main() {
 	// single := flag.Bool("m", false, "single image http mode, default mjpeg video")
 	addr := flag.String("l", ":8080", "addr to listien")
 	fps := flag.Bool("p", false, "print fps info")
+	blur := flag.Float64("b", 0, "Gaussian blur sigma before edge detection, 0 is none")
 	flag.Parse()
 
 	cam, err := webcam.Open(*dev)
@@ -130,7 +131,7 @@ FMT:
 		back chan struct{}      = make(chan struct{})
 	)
 
-	go encodeToImage(cam, back, fi, li, w, h, f)
+	go encodeToImage(cam, back, fi, li, w, h, f, sobel.WithBlur(*blur))
 	go httpVideo(*addr, li)
 
 	timeout := uint32(5) //5 seconds
@@ -182,7 +183,7 @@ FMT:
 }
 
 func encodeToImage(wc *webcam.Webcam, back chan struct{}, fi chan []byte,
-	li chan *bytes.Buffer, w, h uint32, format webcam.PixelFormat) {
+	li chan *bytes.Buffer, w, h uint32, format webcam.PixelFormat, opts ...sobel.Option) {
 
 	var (
 		frame []byte
@@ -207,7 +208,7 @@ func encodeToImage(wc *webcam.Webcam, back chan struct{}, fi chan []byte,
 				grayImg.Pix[i] = frame[i2] //copy only Y set
 			}
 			//select edges
-			edges := sobel.FilterGrayMath(grayImg)
+			edges := sobel.FilterGrayMath(grayImg, opts...)
 			//convert to jpeg
 			buf = &bytes.Buffer{}
 			if err := jpeg.Encode(buf, edges, nil); err != nil {
@@ -0,0 +1,90 @@
+package sobel
+
+import (
+	"image"
+	"runtime"
+	"sync"
+)
+
+// Executor runs filter tiles on a bounded number of goroutines. All the
+// calls sharing an Executor share its limit, so concurrent callers don't
+// oversubscribe the CPU.
+type Executor struct {
+	tokens chan struct{}
+}
+
+// NewExecutor returns an Executor running at most workers tiles at once,
+// workers <= 0 means runtime.GOMAXPROCS(0)
+func NewExecutor(workers int) *Executor {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &Executor{tokens: make(chan struct{}, workers)}
+}
+
+// DefaultExecutor is used when WithExecutor is not given
+var DefaultExecutor = NewExecutor(0)
+
+// Workers returns the number of tiles e runs at once
+func (e *Executor) Workers() int {
+	return cap(e.tokens)
+}
+
+// run runs all tasks and waits for them to finish
+func (e *Executor) run(tasks []func()) {
+	var wg sync.WaitGroup
+	wg.Add(len(tasks))
+	for _, task := range tasks {
+		go func(task func()) {
+			defer wg.Done()
+			e.tokens <- struct{}{}
+			defer func() { <-e.tokens }()
+			task()
+		}(task)
+	}
+	wg.Wait()
+}
+
+// minBandRows keeps bands from getting so thin that halo rows dominate
+const minBandRows = 16
+
+// runTiled is filter(img) computed by row bands on o's executor.
+// filter must compute only pixels that have a complete neighbourhood of
+// radius r, each band gets r halo rows above and below, so the result is
+// byte-identical to the sequential one.
+func runTiled[T, U Pixel](img *Plane[T], r int, o *options, filter func(*Plane[T]) *Plane[U]) *Plane[U] {
+	b := img.Rect
+	workers := o.workers
+	if workers == 0 {
+		workers = o.executor.Workers()
+	}
+	if workers <= 1 {
+		return filter(img)
+	}
+	bandRows := (b.Dy() + workers - 1) / workers
+	if bandRows < minBandRows {
+		bandRows = minBandRows
+	}
+	if bandRows >= b.Dy() {
+		return filter(img)
+	}
+
+	filtered := NewPlane[U](b)
+	var tasks []func()
+	for y0 := b.Min.Y; y0 < b.Max.Y; y0 += bandRows {
+		y1 := y0 + bandRows
+		if y1 > b.Max.Y {
+			y1 = b.Max.Y
+		}
+		band := image.Rect(b.Min.X, y0, b.Max.X, y1)
+		tasks = append(tasks, func() {
+			halo := band.Inset(-r).Intersect(b)
+			res := filter(img.SubPlane(halo))
+			for y := band.Min.Y; y < band.Max.Y; y++ {
+				copy(filtered.Pix[filtered.Offset(b.Min.X, y):][:b.Dx()], res.Pix[res.Offset(b.Min.X, y):][:b.Dx()])
+			}
+		})
+	}
+	o.executor.run(tasks)
+	return filtered
+}
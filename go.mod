@@ -1,8 +1,8 @@
 module github.com/bksworm/sobel
 
-go 1.14
+go 1.18
 
 require (
 	github.com/blackjack/webcam v0.0.0-20200313125108-10ed912a8539
-	golang.org/x/sys v0.0.0-20200509044756-6aff5f38e54f // indirect
+	golang.org/x/sys v0.0.0-20200509044756-6aff5f38e54f
 )
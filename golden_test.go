@@ -0,0 +1,118 @@
+package sobel
+
+import (
+	"errors"
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+var updateGoldens = flag.Bool("update", false, "regenerate the golden images of testdata/golden")
+
+// goldenDir holds an image per filter of conformanceFilters:
+// testdata/golden/<filter>.png
+var goldenDir = filepath.Join("testdata", "golden")
+
+// goldenBackend makes the goldens, it has every filter. Every registered
+// backend is compared with them, the ones that don't have a filter are
+// skipped.
+const goldenBackend = BackendMath
+
+// goldenTolerance allows for float kernels rounded another way on other
+// architectures (e.g. with fused multiply-add), on amd64 they are exact
+var goldenTolerance = tolerance{maxErr: 1, mismatches: 0.001}
+
+// goldenImage returns the 160x120 centre of the test image, with the
+// origin at 0, 0 like decoded goldens
+func (s *SobelTS) goldenImage() *image.Gray {
+	b := s.img.Bounds()
+	c := b.Min.Add(b.Size().Div(2))
+	return toOrigin(s.img.SubImage(image.Rect(c.X-80, c.Y-60, c.X+80, c.Y+60)).(*image.Gray))
+}
+
+// toOrigin returns a copy of img with the origin at 0, 0
+func toOrigin(img *image.Gray) *image.Gray {
+	b := img.Bounds()
+	res := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		copy(res.Pix[res.PixOffset(0, y-b.Min.Y):], img.Pix[img.PixOffset(b.Min.X, y):][:b.Dx()])
+	}
+	return res
+}
+
+func (s *SobelTS) Test_Golden(t *testing.T) {
+	img := s.goldenImage()
+	filters := conformanceFilters()
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		opts := filters[name]
+		path := filepath.Join(goldenDir, name+".png")
+		if *updateGoldens {
+			golden, err := Apply(img, append(opts[:len(opts):len(opts)], WithBackend(goldenBackend))...)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			//BorderCrop keeps the input coordinates, PNG doesn't
+			if err := writeGolden(path, toOrigin(golden)); err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+		}
+		want, err := readGolden(path)
+		if err != nil {
+			t.Errorf("%s: %v, the goldens are made with go test -run TestIt/Test_Golden -update", path, err)
+			continue
+		}
+		for _, backend := range testBackends() {
+			got, err := Apply(img, append(opts[:len(opts):len(opts)], WithBackend(backend))...)
+			if errors.Is(err, ErrUnsupported) {
+				continue
+			} else if err != nil {
+				t.Fatalf("%s %s: %v", backend, name, err)
+			}
+			got = toOrigin(got)
+			if want.Bounds() != got.Bounds() {
+				t.Errorf("%s %s: bounds %v, golden %v", backend, path, got.Bounds(), want.Bounds())
+				continue
+			}
+			if c := compareGray(got, want); !c.within(goldenTolerance) {
+				t.Errorf("%s %s: %v", backend, path, c)
+				writeDiff(t, "golden_"+backend+"_"+name, c.diff)
+			}
+		}
+	}
+}
+
+func writeGolden(path string, img *image.Gray) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readGolden(path string) (*image.Gray, error) {
+	img, err := decodePng(path)
+	if err != nil {
+		return nil, err
+	}
+	if gray, ok := img.(*image.Gray); ok {
+		return gray, nil
+	}
+	return ToGrayscale(img), nil
+}
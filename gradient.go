@@ -0,0 +1,183 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Gradient holds signed X and Y kernel responses of an image. Like in
+// image.Gray, the values of (x, y) are at Dx[Offset(x, y)] and Dy[Offset(x, y)].
+type Gradient struct {
+	Dx, Dy []float32
+	Stride int
+	Rect   image.Rectangle
+}
+
+// NewGradient returns a zero gradient with the given bounds
+func NewGradient(r image.Rectangle) *Gradient {
+	n := r.Dx() * r.Dy()
+	return &Gradient{
+		Dx:     make([]float32, n),
+		Dy:     make([]float32, n),
+		Stride: r.Dx(),
+		Rect:   r,
+	}
+}
+
+func (g *Gradient) Bounds() image.Rectangle {
+	return g.Rect
+}
+
+// Offset returns the index of (x, y) in Dx and Dy
+func (g *Gradient) Offset(x, y int) int {
+	return (y-g.Rect.Min.Y)*g.Stride + (x - g.Rect.Min.X)
+}
+
+// At returns X and Y responses at (x, y), zeros outside of the bounds
+func (g *Gradient) At(x, y int) (dx, dy float32) {
+	if !(image.Point{x, y}.In(g.Rect)) {
+		return 0, 0
+	}
+	i := g.Offset(x, y)
+	return g.Dx[i], g.Dy[i]
+}
+
+// Magnitude returns sqrt(Dx² + Dy²) at (x, y)
+func (g *Gradient) Magnitude(x, y int) float64 {
+	dx, dy := g.At(x, y)
+	return math.Hypot(float64(dx), float64(dy))
+}
+
+// Orientation returns atan2(Dy, Dx) at (x, y) in radians, from -π to π.
+// Y axis points down as in image coordinates.
+func (g *Gradient) Orientation(x, y int) float64 {
+	dx, dy := g.At(x, y)
+	return math.Atan2(float64(dy), float64(dx))
+}
+
+// MagnitudeImage returns the magnitude as an image, by default the L2
+// norm rounded and clipped to 255. It takes the magnitude and mapping
+// options.
+func (g *Gradient) MagnitudeImage(opts ...Option) (*image.Gray, error) {
+	o := newOptions(opts)
+	if err := o.only("MagnitudeImage", optMagnitude|optMapping); err != nil {
+		return nil, err
+	}
+	if err := o.validateMagnitude(); err != nil {
+		return nil, err
+	}
+	return g.magnitudeImage(&o), nil
+}
+
+func (g *Gradient) magnitudeImage(o *options) *image.Gray {
+	res := image.NewGray(g.Rect)
+	var max float64
+	if o.mapping == MappingNormalize {
+		for y := g.Rect.Min.Y; y < g.Rect.Max.Y; y++ {
+			i := g.Offset(g.Rect.Min.X, y)
+			for x := 0; x < g.Rect.Dx(); x++ {
+				max = math.Max(max, o.mag.norm(float64(g.Dx[i+x]), float64(g.Dy[i+x])))
+			}
+		}
+	}
+	scale, offset := o.linear(max)
+	for y := g.Rect.Min.Y; y < g.Rect.Max.Y; y++ {
+		i := g.Offset(g.Rect.Min.X, y)
+		p := res.PixOffset(g.Rect.Min.X, y)
+		for x := 0; x < g.Rect.Dx(); x++ {
+			res.Pix[p+x] = mapPixel(o.mag.norm(float64(g.Dx[i+x]), float64(g.Dy[i+x])), scale, offset)
+		}
+	}
+	return res
+}
+
+// SubGradient returns a gradient representing the portion r of g,
+// the values are shared
+func (g *Gradient) SubGradient(r image.Rectangle) *Gradient {
+	r = r.Intersect(g.Rect)
+	if r.Empty() {
+		return &Gradient{Stride: g.Stride}
+	}
+	i := g.Offset(r.Min.X, r.Min.Y)
+	return &Gradient{
+		Dx:     g.Dx[i:],
+		Dy:     g.Dy[i:],
+		Stride: g.Stride,
+		Rect:   r,
+	}
+}
+
+// ApplyGradient is Apply returning signed X and Y responses instead of a
+// magnitude image. Pixels without a complete neighbourhood are 0 unless
+// a border policy says otherwise, exactly as in Apply. Compass filters
+// have no X and Y responses and return an error. It takes the options of
+// Apply but the magnitude, mapping and convolution ones.
+func ApplyGradient(img image.Image, opts ...Option) (*Gradient, error) {
+	o := newOptions(opts)
+	if err := o.only("ApplyGradient", optGradient); err != nil {
+		return nil, err
+	}
+	return applyGradient(img, &o)
+}
+
+func applyGradient(img image.Image, o *options) (*Gradient, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if !o.custom() && o.kernel.compass() != nil {
+		return nil, fmt.Errorf("sobel: %v is a compass filter, it has no gradient", o.kernel)
+	}
+	b, err := o.selectBackend(func(Backend) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+
+	grayImg := o.gray(img)
+	return gradientBackend(b, o.smooth(grayImg), o)
+}
+
+// gradientBorder runs filter, which writes responses of the pixels that
+// have a complete neighbourhood of radius r into g, with the border
+// policy and workers of o.
+func gradientBorder(grayImg *image.Gray, r int, o *options, filter func(img *image.Gray, g *Gradient)) *Gradient {
+	return gradientBorderPlane(GrayPlane(grayImg), r, o, func(img *Plane[uint8], g *Gradient) {
+		filter(PlaneGray(img), g)
+	})
+}
+
+// gradientBorderPlane is gradientBorder for planes of any pixel type
+func gradientBorderPlane[T Pixel](img *Plane[T], r int, o *options, filter func(img *Plane[T], g *Gradient)) *Gradient {
+	//like compassBorder, the filter only writes pixels of img bounds
+	g := NewGradient(img.Rect)
+	withBorderPlane(img, r, o, func(img *Plane[T]) *Plane[uint8] {
+		filter(img, g)
+		return NewPlane[uint8](img.Rect)
+	})
+	if o.border == BorderCrop {
+		g = g.SubGradient(g.Rect.Inset(r))
+	}
+	return g
+}
+
+// gradientPlane runs the kernels of o on planes of any pixel type
+func gradientPlane[T Pixel](p *Plane[T], o *options) *Gradient {
+	kx, ky := o.kernels()
+	return gradientBorderPlane(p, kernelsRadius(kx, ky), o, func(img *Plane[T], g *Gradient) {
+		kernelGradient(img, kx, ky, g)
+	})
+}
+
+// kernelGradient writes the responses of kx and ky of the pixels of img
+// that have a complete neighbourhood into g
+func kernelGradient[T Pixel](img *Plane[T], kx, ky *Kernel, g *Gradient) {
+	r := kernelsRadius(kx, ky)
+	min := img.Rect.Min
+	planeRows(img, kx, ky, func(y int, rx, ry []float64) {
+		i := g.Offset(min.X, y)
+		for x := r; x < len(rx)-r; x++ {
+			g.Dx[i+x] = float32(rx[x])
+			g.Dy[i+x] = float32(ry[x])
+		}
+	})
+}
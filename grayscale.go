@@ -1,20 +1,160 @@
 package sobel
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 )
 
+// Luma selects how colours are weighted into gray
+type Luma int
+
+const (
+	LumaBT601   Luma = iota //0.299 R + 0.587 G + 0.114 B, as color.GrayModel
+	LumaBT709               //0.2126 R + 0.7152 G + 0.0722 B
+	LumaAverage             //(R + G + B) / 3
+	LumaRed                 //R channel only
+	LumaGreen               //G channel only
+	LumaBlue                //B channel only
+)
+
+func (l Luma) String() string {
+	switch l {
+	case LumaBT601:
+		return "BT601"
+	case LumaBT709:
+		return "BT709"
+	case LumaAverage:
+		return "Average"
+	case LumaRed:
+		return "Red"
+	case LumaGreen:
+		return "Green"
+	case LumaBlue:
+		return "Blue"
+	}
+	return fmt.Sprintf("Luma(%d)", int(l))
+}
+
+func (l Luma) valid() bool {
+	return l >= LumaBT601 && l <= LumaBlue
+}
+
+// weights returns the weights of 16-bit channels, they sum to 1<<16
+func (l Luma) weights() (wr, wg, wb uint32) {
+	switch l {
+	case LumaBT709:
+		return 13933, 46871, 4732
+	case LumaAverage:
+		return 21845, 21846, 21845
+	case LumaRed:
+		return 1 << 16, 0, 0
+	case LumaGreen:
+		return 0, 1 << 16, 0
+	case LumaBlue:
+		return 0, 0, 1 << 16
+	}
+	//the weights of color.GrayModel
+	return 19595, 38470, 7471
+}
+
+// ToGrayscale returns img converted to gray with LumaBT601 weights. It is
+// a copy even if img is gray already.
 func ToGrayscale(img image.Image) *image.Gray {
-	max := img.Bounds().Max
-	min := img.Bounds().Min
-
-	var filtered = image.NewGray(image.Rect(max.X, max.Y, min.X, min.Y))
-	for x := 0; x < max.X; x++ {
-		for y := 0; y < max.Y; y++ {
-			grayColor := color.GrayModel.Convert(img.At(x, y))
-			filtered.Set(x, y, grayColor)
+	return ToGrayscaleLuma(img, LumaBT601)
+}
+
+// ToGrayscaleLuma returns img converted to gray with the luma weights,
+// unknown ones are LumaBT601
+func ToGrayscaleLuma(img image.Image, luma Luma) *image.Gray {
+	dst := image.NewGray(img.Bounds())
+	toGrayscale(dst, img, luma)
+	return dst
+}
+
+// ToGrayscaleInto converts img to gray into dst, which must have the
+// bounds of img, so that a buffer can be reused for video frames
+func ToGrayscaleInto(dst *image.Gray, img image.Image, luma Luma) error {
+	if dst.Bounds() != img.Bounds() {
+		return fmt.Errorf("sobel: gray bounds %v differ from image bounds %v", dst.Bounds(), img.Bounds())
+	}
+	if !luma.valid() {
+		return fmt.Errorf("sobel: unknown luma %v", luma)
+	}
+	toGrayscale(dst, img, luma)
+	return nil
+}
+
+// toGrayscale has fast paths for the common image types, they give the
+// same result as the color.RGBA64 based conversion of the others except
+// for YCbCr with LumaBT601, which is the Y plane as it is
+func toGrayscale(dst *image.Gray, img image.Image, luma Luma) {
+	b := img.Bounds()
+	wr, wg, wb := luma.weights()
+	gray := func(r, g, b uint32) uint8 {
+		return uint8((wr*r + wg*g + wb*b + 1<<15) >> 24)
+	}
+	rows := func(row func(x, y int, dst []uint8)) {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row(b.Min.X, y, dst.Pix[dst.PixOffset(b.Min.X, y):][:b.Dx()])
+		}
+	}
+
+	switch src := img.(type) {
+	case *image.Gray:
+		rows(func(x, y int, dst []uint8) {
+			copy(dst, src.Pix[src.PixOffset(x, y):])
+		})
+	case *image.YCbCr:
+		if luma == LumaBT601 || !luma.valid() {
+			rows(func(x, y int, dst []uint8) {
+				copy(dst, src.Y[src.YOffset(x, y):])
+			})
+			return
+		}
+		rows(func(x, y int, dst []uint8) {
+			for i := range dst {
+				yi, ci := src.YOffset(x+i, y), src.COffset(x+i, y)
+				r, g, bl, _ := color.YCbCr{Y: src.Y[yi], Cb: src.Cb[ci], Cr: src.Cr[ci]}.RGBA()
+				dst[i] = gray(r, g, bl)
+			}
+		})
+	case *image.RGBA:
+		rows(func(x, y int, dst []uint8) {
+			p := src.Pix[src.PixOffset(x, y):]
+			for i := range dst {
+				dst[i] = gray(uint32(p[4*i])*0x101, uint32(p[4*i+1])*0x101, uint32(p[4*i+2])*0x101)
+			}
+		})
+	case *image.NRGBA:
+		rows(func(x, y int, dst []uint8) {
+			p := src.Pix[src.PixOffset(x, y):]
+			for i := range dst {
+				//premultiplied as color.NRGBA.RGBA does it
+				a := uint32(p[4*i+3])
+				r := uint32(p[4*i]) * 0x101 * a / 0xff
+				g := uint32(p[4*i+1]) * 0x101 * a / 0xff
+				bl := uint32(p[4*i+2]) * 0x101 * a / 0xff
+				dst[i] = gray(r, g, bl)
+			}
+		})
+	case *image.Paletted:
+		var lut [256]uint8
+		for i, c := range src.Palette {
+			r, g, bl, _ := c.RGBA()
+			lut[i] = gray(r, g, bl)
 		}
+		rows(func(x, y int, dst []uint8) {
+			for i, v := range src.Pix[src.PixOffset(x, y):][:len(dst)] {
+				dst[i] = lut[v]
+			}
+		})
+	default:
+		rows(func(x, y int, dst []uint8) {
+			for i := range dst {
+				r, g, bl, _ := img.At(x+i, y).RGBA()
+				dst[i] = gray(r, g, bl)
+			}
+		})
 	}
-	return filtered
 }
@@ -0,0 +1,44 @@
+// Package asm has the row kernels of the asm backend of package sobel:
+// 3x3 Sobel responses and their L2 magnitude, in amd64 assembly with
+// AVX2 or SSE4.1 and in go for other CPUs. It is a package of its own
+// because go doesn't assemble .s files of packages that use cgo.
+package asm
+
+import "math"
+
+// Instruction sets the kernels run with
+const (
+	Go    = "go" //no assembly: other architectures, the purego tag or old CPUs
+	SSE41 = "sse4.1"
+	AVX2  = "avx2"
+)
+
+// Level is the instruction set the kernels run with, the widest the CPU
+// has. It is detected at start-up, tests lower it to check the others.
+var Level = Go
+
+// SobelRow writes the 3x3 Sobel responses of the pixels 1..len(row)-2
+// of row into dx and dy at the same indices, above and below are the
+// neighbouring rows of the same length. The assembly does as many pixels
+// as it can in blocks, go does the rest.
+func SobelRow(above, row, below []uint8, dx, dy []int16) {
+	n := len(row) - 2
+	for x := sobelRowAsm(above, row, below, dx, dy, n) + 1; x <= n; x++ {
+		dx[x] = int16(above[x+1]) - int16(above[x-1]) + 2*(int16(row[x+1])-int16(row[x-1])) + int16(below[x+1]) - int16(below[x-1])
+		dy[x] = int16(below[x-1]) + 2*int16(below[x]) + int16(below[x+1]) - int16(above[x-1]) - 2*int16(above[x]) - int16(above[x+1])
+	}
+}
+
+// MagnitudeRow writes sqrt(dx² + dy²) of the pixels 1..len(dst)-2,
+// rounded and saturated to 255
+func MagnitudeRow(dx, dy []int16, dst []uint8) {
+	n := len(dst) - 2
+	for x := magnitudeRowAsm(dx, dy, dst, n) + 1; x <= n; x++ {
+		fX, fY := float64(dx[x]), float64(dy[x])
+		if s := math.Sqrt(fX*fX+fY*fY) + 0.5; s < 255 {
+			dst[x] = uint8(s)
+		} else {
+			dst[x] = 255
+		}
+	}
+}
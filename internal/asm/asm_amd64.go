@@ -0,0 +1,65 @@
+//go:build amd64 && !purego
+
+package asm
+
+import "golang.org/x/sys/cpu"
+
+func init() {
+	switch {
+	case cpu.X86.HasAVX2:
+		Level = AVX2
+	case cpu.X86.HasSSE41:
+		Level = SSE41
+	}
+}
+
+//go:noescape
+func sobelRowAVX2(above, row, below *uint8, dx, dy *int16, n int)
+
+//go:noescape
+func sobelRowSSE41(above, row, below *uint8, dx, dy *int16, n int)
+
+//go:noescape
+func magnitudeRowAVX2(dx, dy *int16, dst *uint8, n int)
+
+//go:noescape
+func magnitudeRowSSE41(dx, dy *int16, dst *uint8, n int)
+
+// sobelRowAsm computes the responses of the first pixels with the
+// instructions of Level and returns how many it did, see SobelRow
+func sobelRowAsm(above, row, below []uint8, dx, dy []int16, n int) int {
+	switch Level {
+	case AVX2:
+		n &^= 15
+		if n > 0 {
+			sobelRowAVX2(&above[0], &row[0], &below[0], &dx[1], &dy[1], n)
+		}
+		return n
+	case SSE41:
+		n &^= 7
+		if n > 0 {
+			sobelRowSSE41(&above[0], &row[0], &below[0], &dx[1], &dy[1], n)
+		}
+		return n
+	}
+	return 0
+}
+
+// magnitudeRowAsm is sobelRowAsm for MagnitudeRow
+func magnitudeRowAsm(dx, dy []int16, dst []uint8, n int) int {
+	switch Level {
+	case AVX2:
+		n &^= 15
+		if n > 0 {
+			magnitudeRowAVX2(&dx[1], &dy[1], &dst[1], n)
+		}
+		return n
+	case SSE41:
+		n &^= 7
+		if n > 0 {
+			magnitudeRowSSE41(&dx[1], &dy[1], &dst[1], n)
+		}
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,189 @@
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// Sobel responses of n pixels, n is a multiple of 16. above, row and
+// below point at the left neighbours of the first pixel, so the window of
+// pixel i is [i, i+2] of each row.
+//
+// dx = (aR - aL) + 2 (rR - rL) + (bR - bL)
+// dy = (bL + 2 bM + bR) - (aL + 2 aM + aR)
+
+// func sobelRowAVX2(above, row, below *uint8, dx, dy *int16, n int)
+TEXT ·sobelRowAVX2(SB), NOSPLIT, $0-48
+	MOVQ above+0(FP), AX
+	MOVQ row+8(FP), BX
+	MOVQ below+16(FP), CX
+	MOVQ dx+24(FP), DX
+	MOVQ dy+32(FP), DI
+	MOVQ n+40(FP), SI
+	XORQ R8, R8
+
+loop:
+	CMPQ SI, $16
+	JLT  done
+	VPMOVZXBW (AX)(R8*1), Y0  // aL
+	VPMOVZXBW 1(AX)(R8*1), Y1 // aM
+	VPMOVZXBW 2(AX)(R8*1), Y2 // aR
+	VPMOVZXBW (CX)(R8*1), Y3  // bL
+	VPMOVZXBW 1(CX)(R8*1), Y4 // bM
+	VPMOVZXBW 2(CX)(R8*1), Y5 // bR
+	VPMOVZXBW (BX)(R8*1), Y6  // rL
+	VPMOVZXBW 2(BX)(R8*1), Y7 // rR
+
+	VPSUBW Y0, Y2, Y8
+	VPSUBW Y3, Y5, Y9
+	VPADDW Y9, Y8, Y8
+	VPSUBW Y6, Y7, Y9
+	VPADDW Y9, Y9, Y9
+	VPADDW Y9, Y8, Y8
+
+	VPADDW Y4, Y4, Y4
+	VPADDW Y3, Y4, Y4
+	VPADDW Y5, Y4, Y4
+	VPADDW Y1, Y1, Y1
+	VPADDW Y0, Y1, Y1
+	VPADDW Y2, Y1, Y1
+	VPSUBW Y1, Y4, Y4
+
+	VMOVDQU Y8, (DX)(R8*2)
+	VMOVDQU Y4, (DI)(R8*2)
+	ADDQ    $16, R8
+	SUBQ    $16, SI
+	JMP     loop
+
+done:
+	VZEROUPPER
+	RET
+
+// func sobelRowSSE41(above, row, below *uint8, dx, dy *int16, n int)
+TEXT ·sobelRowSSE41(SB), NOSPLIT, $0-48
+	MOVQ above+0(FP), AX
+	MOVQ row+8(FP), BX
+	MOVQ below+16(FP), CX
+	MOVQ dx+24(FP), DX
+	MOVQ dy+32(FP), DI
+	MOVQ n+40(FP), SI
+	XORQ R8, R8
+
+loop:
+	CMPQ SI, $8
+	JLT  done
+	PMOVZXBW (AX)(R8*1), X0  // aL
+	PMOVZXBW 1(AX)(R8*1), X1 // aM
+	PMOVZXBW 2(AX)(R8*1), X2 // aR
+	PMOVZXBW (CX)(R8*1), X3  // bL
+	PMOVZXBW 1(CX)(R8*1), X4 // bM
+	PMOVZXBW 2(CX)(R8*1), X5 // bR
+	PMOVZXBW (BX)(R8*1), X6  // rL
+	PMOVZXBW 2(BX)(R8*1), X7 // rR
+
+	MOVO  X2, X8
+	PSUBW X0, X8
+	MOVO  X5, X9
+	PSUBW X3, X9
+	PADDW X9, X8
+	PSUBW X6, X7
+	PADDW X7, X7
+	PADDW X7, X8
+
+	PADDW X4, X4
+	PADDW X3, X4
+	PADDW X5, X4
+	PADDW X1, X1
+	PADDW X0, X1
+	PADDW X2, X1
+	PSUBW X1, X4
+
+	MOVOU X8, (DX)(R8*2)
+	MOVOU X4, (DI)(R8*2)
+	ADDQ  $8, R8
+	SUBQ  $8, SI
+	JMP   loop
+
+done:
+	RET
+
+// sqrt(dx² + dy²) of n responses rounded and saturated to 255, n is a
+// multiple of 16. The sum of squares is exact in float32 and its rounded
+// square root never crosses a .5 boundary, so the result is the one of
+// magnitudeMath.
+
+// func magnitudeRowAVX2(dx, dy *int16, dst *uint8, n int)
+TEXT ·magnitudeRowAVX2(SB), NOSPLIT, $0-32
+	MOVQ dx+0(FP), AX
+	MOVQ dy+8(FP), BX
+	MOVQ dst+16(FP), DI
+	MOVQ n+24(FP), SI
+	XORQ R8, R8
+
+loop:
+	CMPQ SI, $16
+	JLT  done
+	VPMOVSXWD (AX)(R8*2), Y0
+	VPMOVSXWD 16(AX)(R8*2), Y1
+	VPMOVSXWD (BX)(R8*2), Y2
+	VPMOVSXWD 16(BX)(R8*2), Y3
+	VPMULLD   Y0, Y0, Y0
+	VPMULLD   Y1, Y1, Y1
+	VPMULLD   Y2, Y2, Y2
+	VPMULLD   Y3, Y3, Y3
+	VPADDD    Y2, Y0, Y0
+	VPADDD    Y3, Y1, Y1
+	VCVTDQ2PS Y0, Y0
+	VCVTDQ2PS Y1, Y1
+	VSQRTPS   Y0, Y0
+	VSQRTPS   Y1, Y1
+	VCVTPS2DQ Y0, Y0
+	VCVTPS2DQ Y1, Y1
+
+	// packing works within 128-bit lanes: 0-3 8-11 4-7 12-15
+	VPACKUSDW    Y1, Y0, Y0
+	VPERMQ       $0xD8, Y0, Y0
+	VEXTRACTI128 $1, Y0, X1
+	VPACKUSWB    X1, X0, X0
+	VMOVDQU      X0, (DI)(R8*1)
+	ADDQ         $16, R8
+	SUBQ         $16, SI
+	JMP          loop
+
+done:
+	VZEROUPPER
+	RET
+
+// func magnitudeRowSSE41(dx, dy *int16, dst *uint8, n int)
+TEXT ·magnitudeRowSSE41(SB), NOSPLIT, $0-32
+	MOVQ dx+0(FP), AX
+	MOVQ dy+8(FP), BX
+	MOVQ dst+16(FP), DI
+	MOVQ n+24(FP), SI
+	XORQ R8, R8
+
+loop:
+	CMPQ SI, $8
+	JLT  done
+	PMOVSXWD (AX)(R8*2), X0
+	PMOVSXWD 8(AX)(R8*2), X1
+	PMOVSXWD (BX)(R8*2), X2
+	PMOVSXWD 8(BX)(R8*2), X3
+	PMULLD   X0, X0
+	PMULLD   X1, X1
+	PMULLD   X2, X2
+	PMULLD   X3, X3
+	PADDL    X2, X0
+	PADDL    X3, X1
+	CVTPL2PS X0, X0
+	CVTPL2PS X1, X1
+	SQRTPS   X0, X0
+	SQRTPS   X1, X1
+	CVTPS2PL X0, X0
+	CVTPS2PL X1, X1
+	PACKUSDW X1, X0
+	PACKUSWB X0, X0
+	MOVQ     X0, (DI)(R8*1)
+	ADDQ     $8, R8
+	SUBQ     $8, SI
+	JMP      loop
+
+done:
+	RET
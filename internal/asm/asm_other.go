@@ -0,0 +1,11 @@
+//go:build !amd64 || purego
+
+package asm
+
+func sobelRowAsm(above, row, below []uint8, dx, dy []int16, n int) int {
+	return 0
+}
+
+func magnitudeRowAsm(dx, dy []int16, dst []uint8, n int) int {
+	return 0
+}
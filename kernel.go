@@ -0,0 +1,438 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Kernel is a square convolution kernel of an odd size (3x3, 5x5, ...).
+// Weights are given row by row and applied as they are, without flipping,
+// the way the built-in kernels are written. The response is
+// sum(weight * pixel) / Divisor + Offset.
+type Kernel struct {
+	Size    int
+	Weights []float64
+	Divisor float64 //0 means 1, a negative one negates the response
+	Offset  float64
+
+	row, col []float64 //factors given to NewSeparableKernel
+}
+
+// NewKernel returns a kernel with integer weights
+func NewKernel(size int, weights []int) (*Kernel, error) {
+	fw := make([]float64, len(weights))
+	for i, w := range weights {
+		fw[i] = float64(w)
+	}
+	return NewKernelFloat(size, fw)
+}
+
+// NewKernelFloat returns a kernel with float weights
+func NewKernelFloat(size int, weights []float64) (*Kernel, error) {
+	k := &Kernel{Size: size, Weights: append([]float64(nil), weights...)}
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Radius returns the number of pixels the kernel reaches on each side of
+// the centre
+func (k *Kernel) Radius() int {
+	return k.Size / 2
+}
+
+func (k *Kernel) validate() error {
+	if k.Size < 1 || k.Size%2 == 0 {
+		return fmt.Errorf("sobel: kernel size %d is not odd", k.Size)
+	}
+	if len(k.Weights) != k.Size*k.Size {
+		return fmt.Errorf("sobel: %dx%d kernel has %d weights", k.Size, k.Size, len(k.Weights))
+	}
+	if !finite(k.Divisor) {
+		return fmt.Errorf("sobel: invalid kernel divisor %v", k.Divisor)
+	}
+	if !finite(k.Offset) {
+		return fmt.Errorf("sobel: invalid kernel offset %v", k.Offset)
+	}
+	for i, w := range k.Weights {
+		if !finite(w) {
+			return fmt.Errorf("sobel: invalid kernel weight %v at %d", w, i)
+		}
+	}
+	return nil
+}
+
+// finite reports if v is neither NaN nor infinite
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// conv is a Kernel prepared for images with a particular Stride: only
+// non-zero weights are kept together with their Pix offsets from the centre
+type conv struct {
+	offsets []int
+	ints    []int //weights if the kernel is integral, nil otherwise
+	floats  []float64
+	div     float64
+	offset  float64
+}
+
+// prepare returns k ready to run on images with the stride, nil kernel
+// gives nil conv which always responds 0
+func (k *Kernel) prepare(stride int) *conv {
+	if k == nil {
+		return nil
+	}
+	c := &conv{div: k.Divisor, offset: k.Offset}
+	if c.div == 0 {
+		c.div = 1
+	}
+	integral := c.div == 1 && c.offset == 0
+	r := k.Radius()
+	for i, w := range k.Weights {
+		if w == 0 {
+			continue
+		}
+		c.offsets = append(c.offsets, (i/k.Size-r)*stride+(i%k.Size-r))
+		c.floats = append(c.floats, w)
+		integral = integral && w == math.Trunc(w) && math.Abs(w) < 1<<20
+	}
+	if integral {
+		c.ints = make([]int, len(c.floats))
+		for i, w := range c.floats {
+			c.ints[i] = int(w)
+		}
+	}
+	return c
+}
+
+// response returns the kernel response at Pix index i
+func (c *conv) response(pix []uint8, i int) float64 {
+	return convResponse(c, pix, i, c != nil && c.ints != nil)
+}
+
+func (c *conv) responseInt(pix []uint8, i int) int {
+	return convResponseInt(c, pix, i)
+}
+
+// convResponse returns the kernel response at Pix index i, integral
+// kernels may sum in int on integer pixels, which is exact for 8 and
+// 16-bit ones
+func convResponse[T Pixel](c *conv, pix []T, i int, integral bool) float64 {
+	if c == nil {
+		return 0
+	}
+	if integral {
+		return float64(convResponseInt(c, pix, i))
+	}
+	var s float64
+	for j, off := range c.offsets {
+		s += c.floats[j] * float64(pix[i+off])
+	}
+	return s/c.div + c.offset
+}
+
+func convResponseInt[T Pixel](c *conv, pix []T, i int) int {
+	var s int
+	for j, off := range c.offsets {
+		s += c.ints[j] * int(pix[i+off])
+	}
+	return s
+}
+
+// absRound returns |v| rounded to an integer
+func absRound(v float64) uint32 {
+	return uint32(math.Abs(v) + 0.5)
+}
+
+// kernelsRadius is the radius of the larger of two kernels
+func kernelsRadius(kx, ky *Kernel) (r int) {
+	if kx != nil {
+		r = kx.Radius()
+	}
+	if ky != nil && ky.Radius() > r {
+		r = ky.Radius()
+	}
+	return r
+}
+
+// prepareSeparable prepares both kernels for two passes, ok is false if
+// any of them is not separable
+func prepareSeparable(kx, ky *Kernel) (sx, sy *sepConv, ok bool) {
+	if kx != nil {
+		if sx = kx.prepareSeparable(); sx == nil {
+			return nil, nil, false
+		}
+	}
+	if ky != nil {
+		if sy = ky.prepareSeparable(); sy == nil {
+			return nil, nil, false
+		}
+	}
+	return sx, sy, true
+}
+
+// rowFunc receives kx and ky responses of the image row y: r[x-min.X]
+// for x from min.X+radius to max.X-radius, where radius is the larger
+// kernel radius
+type rowFunc func(y int, rx, ry []float64)
+
+// kernelRows calls row for every image row that has complete
+// neighbourhoods, top to bottom. Either kernel may be nil, its
+// responses are 0 then.
+func kernelRows(grayImg *image.Gray, kx, ky *Kernel, row rowFunc) {
+	planeRows(GrayPlane(grayImg), kx, ky, row)
+}
+
+// planeRows is kernelRows for planes of any pixel type
+func planeRows[T Pixel](p *Plane[T], kx, ky *Kernel, row rowFunc) {
+	//two passes are faster even for 3x3 sobel
+	if sx, sy, ok := prepareSeparable(kx, ky); ok {
+		separableRows(p, sx, sy, row)
+		return
+	}
+	directRows(p, kx, ky, row)
+}
+
+// directRows is planeRows in one pass
+func directRows[T Pixel](p *Plane[T], kx, ky *Kernel, row rowFunc) {
+	r := kernelsRadius(kx, ky)
+	max := p.Rect.Max
+	min := p.Rect.Min
+	cx, cy := kx.prepare(p.Stride), ky.prepare(p.Stride)
+	intX := cx != nil && cx.ints != nil && !floatPixel[T]()
+	intY := cy != nil && cy.ints != nil && !floatPixel[T]()
+	rx := make([]float64, max.X-min.X)
+	ry := make([]float64, max.X-min.X)
+
+	for y := min.Y + r; y < max.Y-r; y++ {
+		i := p.Offset(min.X+r, y)
+		for x := r; x < len(rx)-r; x++ {
+			rx[x] = convResponse(cx, p.Pix, i, intX)
+			ry[x] = convResponse(cy, p.Pix, i, intY)
+			i++
+		}
+		row(y, rx, ry)
+	}
+}
+
+// filterGrayKernels combines kx and ky responses with mag for every pixel
+// that has a complete neighbourhood, the rest is left black. ky may be nil
+// for single kernel filters.
+func filterGrayKernels(grayImg *image.Gray, kx, ky *Kernel, mag magnitudeFunc) (filtered *image.Gray) {
+	b := grayImg.Bounds()
+	filtered = image.NewGray(b)
+	r := kernelsRadius(kx, ky)
+	kernelRows(grayImg, kx, ky, func(y int, rx, ry []float64) {
+		o := filtered.PixOffset(b.Min.X, y)
+		for x := r; x < len(rx)-r; x++ {
+			filtered.Pix[o+x] = mag(absRound(rx[x]), absRound(ry[x]))
+		}
+	})
+	return filtered
+}
+
+// NewSeparableKernel returns the kernel col ⊗ row, that is
+// weight[i][j] = col[i] * row[j]. Filters run such kernels as a row pass
+// followed by a column pass, which is 2*Size operations per pixel
+// instead of Size*Size.
+func NewSeparableKernel(row, col []float64) (*Kernel, error) {
+	if len(row) != len(col) {
+		return nil, fmt.Errorf("sobel: separable kernel row has %d weights, column %d", len(row), len(col))
+	}
+	size := len(row)
+	weights := make([]float64, size*size)
+	for i := range col {
+		for j := range row {
+			weights[i*size+j] = col[i] * row[j]
+		}
+	}
+	k, err := NewKernelFloat(size, weights)
+	if err != nil {
+		return nil, err
+	}
+	k.row = append([]float64(nil), row...)
+	k.col = append([]float64(nil), col...)
+	return k, nil
+}
+
+// Separable returns row and col such that the kernel weights are
+// col[i] * row[j], ok is false if the kernel is not separable.
+// Kernels that are separable are run as two passes automatically.
+func (k *Kernel) Separable() (row, col []float64, ok bool) {
+	if k.validate() != nil {
+		return nil, nil, false
+	}
+	if k.row != nil && k.matches(k.row, k.col) {
+		return append([]float64(nil), k.row...), append([]float64(nil), k.col...), true
+	}
+	//the row with the largest weight is the row factor, the column
+	//factor is what the other rows are scaled by
+	n := k.Size
+	pivot := 0
+	for i, w := range k.Weights {
+		if math.Abs(w) > math.Abs(k.Weights[pivot]) {
+			pivot = i
+		}
+	}
+	if k.Weights[pivot] == 0 {
+		return nil, nil, false
+	}
+	pi, pj := pivot/n, pivot%n
+	row = append([]float64(nil), k.Weights[pi*n:pi*n+n]...)
+	col = make([]float64, n)
+	minCol := math.Inf(1)
+	for i := range col {
+		col[i] = k.Weights[i*n+pj] / k.Weights[pivot]
+		if a := math.Abs(col[i]); a != 0 && a < minCol {
+			minCol = a
+		}
+	}
+	//prefer small integers in the column, [1 2 1] rather than [.5 1 .5]
+	for i := range col {
+		col[i] /= minCol
+	}
+	for j := range row {
+		row[j] *= minCol
+	}
+	if !k.matches(row, col) {
+		return nil, nil, false
+	}
+	return row, col, true
+}
+
+// matches reports if col ⊗ row equals the kernel weights
+func (k *Kernel) matches(row, col []float64) bool {
+	n := k.Size
+	if len(row) != n || len(col) != n {
+		return false
+	}
+	for i := range col {
+		for j := range row {
+			w := k.Weights[i*n+j]
+			if math.Abs(col[i]*row[j]-w) > 1e-9*math.Max(1, math.Abs(w)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sepConv is a separable Kernel prepared to run
+type sepConv struct {
+	r           int
+	row, col    []float64 //non-zero weights
+	rowX, colY  []int     //and their positions relative to the centre
+	div, offset float64
+}
+
+// prepareSeparable returns nil if k is not separable
+func (k *Kernel) prepareSeparable() *sepConv {
+	row, col, ok := k.Separable()
+	if !ok {
+		return nil
+	}
+	c := &sepConv{r: k.Radius(), div: k.Divisor, offset: k.Offset}
+	if c.div == 0 {
+		c.div = 1
+	}
+	for j, w := range row {
+		if w != 0 {
+			c.row = append(c.row, w)
+			c.rowX = append(c.rowX, j-c.r)
+		}
+	}
+	for i, w := range col {
+		if w != 0 {
+			c.col = append(c.col, w)
+			c.colY = append(c.colY, i-c.r)
+		}
+	}
+	return c
+}
+
+// sepPass keeps the row pass results of the last 2r+1 rows
+type sepPass struct {
+	*sepConv
+	rows [][]float64
+	acc  []float64 //column pass sums
+	next int       //the next image row to run the row pass on
+}
+
+// newSepPass starts the row pass at the row top, kernels smaller than the
+// largest one of a filter start later
+func newSepPass(c *sepConv, width, top int) *sepPass {
+	p := &sepPass{sepConv: c, rows: make([][]float64, 2*c.r+1), acc: make([]float64, width), next: top}
+	for i := range p.rows {
+		p.rows[i] = make([]float64, width)
+	}
+	return p
+}
+
+// sepResponse returns the response of every pixel of the row y, which
+// must be at least r rows from the top and bottom, into dst. The sums are
+// float64, exact for integral kernels on 8 and 16-bit pixels.
+func sepResponse[T Pixel](p *sepPass, img *Plane[T], y int, dst []float64) {
+	b := img.Rect
+	size := len(p.rows)
+	from, to := p.r, b.Dx()-p.r
+	for ; p.next <= y+p.r; p.next++ {
+		buf := p.rows[(p.next-b.Min.Y)%size]
+		pix := img.Pix[img.Offset(b.Min.X, p.next):]
+		for x := from; x < to; x++ {
+			var s float64
+			for j, dx := range p.rowX {
+				s += p.row[j] * float64(pix[x+dx])
+			}
+			buf[x] = s
+		}
+	}
+	acc := p.acc[from:to]
+	for i := range acc {
+		acc[i] = 0
+	}
+	for i, dy := range p.colY {
+		w := p.col[i]
+		for x, v := range p.rows[(y+dy-b.Min.Y)%size][from:to] {
+			acc[x] += w * v
+		}
+	}
+	for x, s := range acc {
+		dst[from+x] = s/p.div + p.offset
+	}
+}
+
+// separableRows is planeRows for separable kernels, sx or sy may be nil
+func separableRows[T Pixel](img *Plane[T], sx, sy *sepConv, row rowFunc) {
+	b := img.Rect
+	r := 0
+	for _, c := range []*sepConv{sx, sy} {
+		if c != nil && c.r > r {
+			r = c.r
+		}
+	}
+	if b.Dx() <= 2*r {
+		return //no pixel has a complete neighbourhood
+	}
+	var passes []*sepPass
+	var resp [][]float64
+	for _, c := range []*sepConv{sx, sy} {
+		var p *sepPass
+		if c != nil {
+			p = newSepPass(c, b.Dx(), b.Min.Y+r-c.r)
+		}
+		passes = append(passes, p)
+		resp = append(resp, make([]float64, b.Dx()))
+	}
+
+	for y := b.Min.Y + r; y < b.Max.Y-r; y++ {
+		for i, p := range passes {
+			if p != nil {
+				sepResponse(p, img, y, resp[i])
+			}
+		}
+		row(y, resp[0], resp[1])
+	}
+}
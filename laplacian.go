@@ -0,0 +1,202 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Response holds signed responses of a second derivative filter. Like in
+// image.Gray, the value of (x, y) is at Pix[Offset(x, y)].
+type Response struct {
+	Pix    []float32
+	Stride int
+	Rect   image.Rectangle
+}
+
+// NewResponse returns a zero response with the given bounds
+func NewResponse(r image.Rectangle) *Response {
+	return &Response{
+		Pix:    make([]float32, r.Dx()*r.Dy()),
+		Stride: r.Dx(),
+		Rect:   r,
+	}
+}
+
+func (r *Response) Bounds() image.Rectangle {
+	return r.Rect
+}
+
+// Offset returns the index of (x, y) in Pix
+func (r *Response) Offset(x, y int) int {
+	return (y-r.Rect.Min.Y)*r.Stride + (x - r.Rect.Min.X)
+}
+
+// At returns the response at (x, y), zero outside of the bounds
+func (r *Response) At(x, y int) float32 {
+	if !(image.Point{x, y}.In(r.Rect)) {
+		return 0
+	}
+	return r.Pix[r.Offset(x, y)]
+}
+
+// SubResponse returns a response representing the portion rect of r,
+// the values are shared
+func (r *Response) SubResponse(rect image.Rectangle) *Response {
+	rect = rect.Intersect(r.Rect)
+	if rect.Empty() {
+		return &Response{Stride: r.Stride}
+	}
+	return &Response{
+		Pix:    r.Pix[r.Offset(rect.Min.X, rect.Min.Y):],
+		Stride: r.Stride,
+		Rect:   rect,
+	}
+}
+
+// Laplacian returns the signed response of the 3x3 Laplacian kernel
+// (the Laplasian filter type): negative on the bright side of edges,
+// positive on the dark one. It takes the border, pre-smoothing, luma and
+// workers options.
+func Laplacian(img image.Image, opts ...Option) (*Response, error) {
+	return applyResponse("Laplacian", img, opts, laplasianX, nil, 1)
+}
+
+// LoG returns the Laplacian of Gaussian of sigma, normalised by sigma² so
+// that the responses at different scales are comparable. It takes the
+// options of Laplacian.
+func LoG(img image.Image, sigma float64, opts ...Option) (*Response, error) {
+	if !(sigma > 0) || math.IsInf(sigma, 0) {
+		return nil, fmt.Errorf("sobel: invalid LoG sigma %v", sigma)
+	}
+	kxx, kyy := logKernels(sigma)
+	return applyResponse("LoG", img, opts, kxx, kyy, 1)
+}
+
+// DoG returns the difference of Gaussians of sigma1 and sigma2, the image
+// smoothed by sigma1 minus the one smoothed by the larger sigma2. It is
+// an approximation of LoG with the opposite sign. It takes the options of
+// Laplacian.
+func DoG(img image.Image, sigma1, sigma2 float64, opts ...Option) (*Response, error) {
+	if !(sigma1 > 0 && sigma2 > sigma1) || math.IsInf(sigma2, 0) {
+		return nil, fmt.Errorf("sobel: invalid DoG sigmas %v, %v", sigma1, sigma2)
+	}
+	return applyResponse("DoG", img, opts, gaussianKernel(sigma1, 0), gaussianKernel(sigma2, 0), -1)
+}
+
+// MarrHildreth returns the binary edge map (0 or 255) of the zero
+// crossings of LoG of sigma whose slope is above threshold, it takes the
+// options of Laplacian
+func MarrHildreth(img image.Image, sigma, threshold float64, opts ...Option) (*image.Gray, error) {
+	resp, err := LoG(img, sigma, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return ZeroCrossings(resp, threshold), nil
+}
+
+// ZeroCrossings returns the binary edge map (0 or 255) of the places
+// where resp changes sign between neighbours by more than threshold. Of
+// the two neighbours the one closer to zero is marked, so edges are one
+// pixel wide.
+func ZeroCrossings(resp *Response, threshold float64) *image.Gray {
+	b := resp.Rect
+	edges := image.NewGray(b)
+	mark := func(x, y int) {
+		edges.Pix[edges.PixOffset(x, y)] = 255
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			v := float64(resp.At(x, y))
+			if v == 0 {
+				//a crossing through a zero pixel: -0+
+				for _, d := range [2]image.Point{{1, 0}, {0, 1}} {
+					p, q := float64(resp.At(x-d.X, y-d.Y)), float64(resp.At(x+d.X, y+d.Y))
+					if p*q < 0 && math.Abs(p-q) > threshold {
+						mark(x, y)
+					}
+				}
+				continue
+			}
+			for _, d := range [2]image.Point{{1, 0}, {0, 1}} {
+				n := image.Point{x + d.X, y + d.Y}
+				if !n.In(b) {
+					continue
+				}
+				q := float64(resp.At(n.X, n.Y))
+				if v*q >= 0 || math.Abs(v-q) <= threshold {
+					continue
+				}
+				if math.Abs(v) <= math.Abs(q) {
+					mark(x, y)
+				} else {
+					mark(n.X, n.Y)
+				}
+			}
+		}
+	}
+	return edges
+}
+
+// logKernels returns the separable parts of sigma² LoG: d²/dx² and d²/dy²
+// of the Gaussian, the response is the sum of both
+func logKernels(sigma float64) (kxx, kyy *Kernel) {
+	r := int(math.Ceil(4 * sigma))
+	g := make([]float64, 2*r+1)
+	g2 := make([]float64, 2*r+1)
+	var sum, sum2 float64
+	for i := range g {
+		d := float64(i - r)
+		g[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += g[i]
+	}
+	for i := range g {
+		d := float64(i - r)
+		g[i] /= sum
+		g2[i] = g[i] * (d*d - sigma*sigma) / (sigma * sigma)
+		sum2 += g2[i]
+	}
+	//flat images respond 0
+	for i := range g2 {
+		g2[i] -= sum2 / float64(len(g2))
+	}
+	kxx, _ = NewSeparableKernel(g2, g)
+	kyy, _ = NewSeparableKernel(g, g2)
+	return kxx, kyy
+}
+
+// applyResponse returns the response of kx plus sign times the one of ky
+// for fn
+func applyResponse(fn string, img image.Image, opts []Option, kx, ky *Kernel, sign float64) (*Response, error) {
+	o := newOptions(opts)
+	if err := o.only(fn, optBorder|optBlur|optLuma|optWorkers); err != nil {
+		return nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	grayImg := o.gray(img)
+	r := kernelsRadius(kx, ky)
+	return responseBorder(o.smooth(grayImg), r, &o, func(img *image.Gray, resp *Response) {
+		min := img.Bounds().Min
+		kernelRows(img, kx, ky, func(y int, rx, ry []float64) {
+			i := resp.Offset(min.X, y)
+			for x := r; x < len(rx)-r; x++ {
+				resp.Pix[i+x] = float32(rx[x] + sign*ry[x])
+			}
+		})
+	}), nil
+}
+
+// responseBorder is gradientBorder for a Response
+func responseBorder(grayImg *image.Gray, r int, o *options, filter func(img *image.Gray, resp *Response)) *Response {
+	resp := NewResponse(grayImg.Bounds())
+	withBorder(grayImg, r, o, func(img *image.Gray) *image.Gray {
+		filter(img, resp)
+		return image.NewGray(img.Bounds())
+	})
+	if o.border == BorderCrop {
+		resp = resp.SubResponse(resp.Rect.Inset(r))
+	}
+	return resp
+}
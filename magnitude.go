@@ -0,0 +1,221 @@
+package sobel
+
+import (
+	"fmt"
+	"math"
+)
+
+// Magnitude selects how Dx and Dy responses are combined into one pixel
+type Magnitude int
+
+const (
+	MagnitudeL2      Magnitude = iota //sqrt(Dx² + Dy²)
+	MagnitudeL1                       //|Dx| + |Dy|
+	MagnitudeLInf                     //max(|Dx|, |Dy|)
+	MagnitudeSquared                  //Dx² + Dy²
+)
+
+func (m Magnitude) String() string {
+	switch m {
+	case MagnitudeL2:
+		return "L2"
+	case MagnitudeL1:
+		return "L1"
+	case MagnitudeLInf:
+		return "LInf"
+	case MagnitudeSquared:
+		return "Squared"
+	}
+	return fmt.Sprintf("Magnitude(%d)", int(m))
+}
+
+func (m Magnitude) valid() bool {
+	return m >= MagnitudeL2 && m <= MagnitudeSquared
+}
+
+// norm combines the responses, m must be valid
+func (m Magnitude) norm(dx, dy float64) float64 {
+	switch m {
+	case MagnitudeL1:
+		return math.Abs(dx) + math.Abs(dy)
+	case MagnitudeLInf:
+		return math.Max(math.Abs(dx), math.Abs(dy))
+	case MagnitudeSquared:
+		return dx*dx + dy*dy
+	}
+	return math.Sqrt(dx*dx + dy*dy)
+}
+
+// Mapping selects how magnitudes are turned into 0..255 pixels. The
+// result is always rounded and clipped, it never wraps around.
+type Mapping int
+
+const (
+	MappingSaturate  Mapping = iota //values above 255 become 255
+	MappingScale                    //multiplied by the WithScale factor
+	MappingNormalize                //the maximum of the image becomes 255
+	MappingRange                    //WithRange lo..hi becomes 0..255
+)
+
+func (m Mapping) String() string {
+	switch m {
+	case MappingSaturate:
+		return "Saturate"
+	case MappingScale:
+		return "Scale"
+	case MappingNormalize:
+		return "Normalize"
+	case MappingRange:
+		return "Range"
+	}
+	return fmt.Sprintf("Mapping(%d)", int(m))
+}
+
+func (o *options) validateMagnitude() error {
+	if !o.mag.valid() {
+		return fmt.Errorf("sobel: unknown magnitude %v", o.mag)
+	}
+	switch o.mapping {
+	case MappingSaturate, MappingNormalize:
+	case MappingScale:
+		if !(o.scale > 0) || math.IsInf(o.scale, 0) {
+			return fmt.Errorf("sobel: invalid scale %v", o.scale)
+		}
+	case MappingRange:
+		if !(o.hi > o.lo) || math.IsInf(o.hi-o.lo, 0) {
+			return fmt.Errorf("sobel: invalid range %v..%v", o.lo, o.hi)
+		}
+	default:
+		return fmt.Errorf("sobel: unknown mapping %v", o.mapping)
+	}
+	return nil
+}
+
+// linear returns the factor and offset of the mapping, max is the
+// maximum magnitude of the image for MappingNormalize
+func (o *options) linear(max float64) (scale, offset float64) {
+	return o.linearTo(max, 255)
+}
+
+// linearTo is linear for outputs whose white is full, e.g. 65535
+func (o *options) linearTo(max, full float64) (scale, offset float64) {
+	switch o.mapping {
+	case MappingScale:
+		return o.scale, 0
+	case MappingNormalize:
+		if max == 0 {
+			return 0, 0
+		}
+		return full / max, 0
+	case MappingRange:
+		scale = full / (o.hi - o.lo)
+		return scale, -o.lo * scale
+	}
+	return 1, 0
+}
+
+// mapPixel rounds v*scale + offset and clips it to 0..255
+func mapPixel(v, scale, offset float64) uint8 {
+	v = v*scale + offset + 0.5
+	if v >= 255 {
+		return 255
+	} else if v < 0 || v != v {
+		return 0
+	}
+	return uint8(v)
+}
+
+// magnitude returns the magnitudeFunc of the options for every mapping
+// but MappingNormalize, which needs the whole image. l2 is the backend
+// specific saturated L2, the other norms are the same for every backend.
+func (o *options) magnitude(l2 magnitudeFunc) magnitudeFunc {
+	scale, offset := o.linear(0)
+	return magnitudeOf(o.mag, o.mapping != MappingSaturate, scale, offset, l2)
+}
+
+// magnitudeOf is options.magnitude for the norm m, mapped is false for
+// MappingSaturate
+func magnitudeOf(m Magnitude, mapped bool, scale, offset float64, l2 magnitudeFunc) magnitudeFunc {
+	if !mapped {
+		switch m {
+		case MagnitudeL2:
+			return l2
+		case MagnitudeL1:
+			return magnitudeL1
+		case MagnitudeLInf:
+			return magnitudeLInf
+		case MagnitudeSquared:
+			return magnitudeSquared
+		}
+	}
+	norm := m.norm
+	return func(fX, fY uint32) uint8 {
+		return mapPixel(norm(float64(fX), float64(fY)), scale, offset)
+	}
+}
+
+// magnitudeFunc combines absolute X and Y responses into an output pixel
+type magnitudeFunc func(fX, fY uint32) uint8
+
+// roundSqrt returns sqrt(fX² + fY²) rounded and clipped to 255, floorSqrt
+// is an integer square root
+func roundSqrt(fX, fY uint32, floorSqrt func(uint32) uint32) uint8 {
+	if fX > 255 || fY > 255 {
+		return 255 //the squares don't overflow below
+	}
+	n := fX*fX + fY*fY
+	s := floorSqrt(n)
+	if n > s*s+s { //sqrt(n) >= s + 0.5
+		s++
+	}
+	if s > 255 {
+		return 255
+	}
+	return uint8(s)
+}
+
+func magnitudeISqrt(fX, fY uint32) uint8 {
+	return roundSqrt(fX, fY, ISqrt)
+}
+
+func magnitudeFast(fX, fY uint32) uint8 {
+	return roundSqrt(fX, fY, FloorSqrt)
+}
+
+func magnitudeMath(fX, fY uint32) uint8 {
+	fS := math.Sqrt(float64(fX)*float64(fX)+float64(fY)*float64(fY)) + 0.5
+	if fS > 255 {
+		return 255
+	}
+	return uint8(fS)
+}
+
+// magnitudeL1 is |fX| + |fY| clipped to 255
+func magnitudeL1(fX, fY uint32) uint8 {
+	if v := fX + fY; v < 255 {
+		return uint8(v)
+	}
+	return 255
+}
+
+// magnitudeLInf is max(|fX|, |fY|) clipped to 255
+func magnitudeLInf(fX, fY uint32) uint8 {
+	if fY > fX {
+		fX = fY
+	}
+	if fX < 255 {
+		return uint8(fX)
+	}
+	return 255
+}
+
+// magnitudeSquared is fX² + fY² clipped to 255
+func magnitudeSquared(fX, fY uint32) uint8 {
+	if fX > 255 || fY > 255 {
+		return 255
+	}
+	if v := fX*fX + fY*fY; v < 255 {
+		return uint8(v)
+	}
+	return 255
+}
@@ -0,0 +1,37 @@
+//go:build !cgo || !libsimd
+
+package sobel
+
+import "image"
+
+// filterSimd runs the code of FilterGrayAsm for FilterSimd, the fastest
+// backend without libsimd
+func filterSimd(grayImg *image.Gray, o *options) (*image.Gray, string) {
+	return filterGrayAsmBorder(grayImg, o), BackendAsm
+}
+
+// FilterGraySimd runs FilterGrayAsm without libsimd (see the libsimd
+// build tag), the options are the ones of Filter
+func FilterGraySimd(grayImg *image.Gray, opts ...Option) *image.Gray {
+	return filterGraySimdAsm("FilterGraySimd", grayImg, opts)
+}
+
+// FilterGraySimdC runs FilterGrayAsm without libsimd, as FilterGraySimd
+func FilterGraySimdC(grayImg *image.Gray, opts ...Option) *image.Gray {
+	return filterGraySimdAsm("FilterGraySimdC", grayImg, opts)
+}
+
+// filterGraySimdAsm is FilterGrayAsm for fn
+func filterGraySimdAsm(fn string, grayImg *image.Gray, opts []Option) *image.Gray {
+	o, own := legacyOptions(fn, Sobel, opts)
+	if !own {
+		filtered, _ := applyLegacy(grayImg, &o, BackendAsm)
+		return filtered
+	}
+	return filterGrayAsmBorder(grayImg, &o)
+}
+
+// FloorSqrtC is FloorSqrt without cgo
+func FloorSqrtC(x uint32) uint32 {
+	return FloorSqrt(x)
+}
@@ -0,0 +1,39 @@
+//go:build !cgo || !libsimd
+
+package sobel
+
+import (
+	"errors"
+	"image"
+	"testing"
+)
+
+func (s *SobelTS) Test_NoSimd(t *testing.T) {
+	if _, err := Apply(s.img, WithBackend(BackendSimd)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("simd backend without libsimd: %v", err)
+	}
+	if _, err := ApplyGradient(s.img, WithBackend(BackendSimd)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("simd gradient without libsimd: %v", err)
+	}
+	got, backend := FilterSimdBackend(s.img, Sobel)
+	if backend != BackendAsm {
+		t.Errorf("FilterSimd ran %s, expected %s", backend, BackendAsm)
+	}
+	if !sameGray(got, FilterGrayAsm(s.img)) {
+		t.Errorf("FilterSimd differs from FilterGrayAsm")
+	}
+	for name, filter := range map[string]func(*image.Gray, ...Option) *image.Gray{
+		"FilterGraySimd":  FilterGraySimd,
+		"FilterGraySimdC": FilterGraySimdC,
+	} {
+		if !sameGray(filter(s.img, WithBorder(BorderReplicate)), FilterGrayAsm(s.img, WithBorder(BorderReplicate))) {
+			t.Errorf("%s differs from FilterGrayAsm", name)
+		}
+	}
+	if FloorSqrtC(sqrtFrom) != FloorSqrt(sqrtFrom) {
+		t.Errorf("FloorSqrtC differs from FloorSqrt")
+	}
+	if _, backend := FilterSimdBackend(s.img, Prewitt); backend != BackendGo {
+		t.Errorf("FilterSimd ran %s for Prewitt, expected %s", backend, BackendGo)
+	}
+}
@@ -0,0 +1,421 @@
+package sobel
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// Names of the built-in backends, they are registered at start-up (simd
+// only with the libsimd build tag)
+const (
+	BackendGo   = "go"   //pure go, FilterGrayFast
+	BackendMath = "math" //pure go, FilterGrayMath
+	BackendSimd = "simd" //cgo + libsimd, FilterGraySimd, needs the libsimd build tag
+	BackendAsm  = "asm"  //pure go with amd64 assembly, FilterGrayAsm
+)
+
+// ErrUnsupported is wrapped by Apply errors for option combinations
+// a backend can't run, and by the errors of every function for options
+// it doesn't take
+var ErrUnsupported = errors.New("unsupported")
+
+// Option configures Apply and the other functions that take options.
+// Each function documents the options it takes, the others are errors
+// wrapping ErrUnsupported, as are invalid values: nothing is ignored or
+// substituted silently. Filter* and FilterGray* functions predate Apply
+// and have no error result, they panic instead, see Filter.
+type Option func(*options)
+
+// optionSet is a set of groups of options, every function takes some
+type optionSet uint
+
+const (
+	optKernel      optionSet = 1 << iota //WithKernel, WithKernels, WithOrder
+	optBackend                           //WithBackend
+	optMagnitude                         //WithMagnitude
+	optConvolution                       //WithConvolution
+	optMapping                           //WithMapping, WithScale, WithRange
+	optBorder                            //WithBorder, WithBorderValue
+	optBlur                              //WithBlur, WithBlurRadius, WithBoxBlur
+	optThresholds                        //WithThresholds, WithAutoThresholds
+	optChannels                          //WithChannels
+	optLuma                              //WithLuma
+	optWorkers                           //WithWorkers, WithExecutor
+
+	//the options of Apply and ApplyGradient
+	optFilter   = optKernel | optBackend | optMagnitude | optConvolution | optMapping | optBorder | optBlur | optLuma | optWorkers
+	optGradient = optKernel | optBackend | optBorder | optBlur | optLuma | optWorkers
+	//the ones of the FilterSpec
+	optSpec = optKernel | optMagnitude | optConvolution | optMapping
+)
+
+var optionNames = [...]string{"kernel", "backend", "magnitude", "convolution", "mapping", "border", "blur", "threshold", "channel", "luma", "worker"}
+
+// only returns an error if options outside of takes were given to fn
+func (o *options) only(fn string, takes optionSet) error {
+	rest := o.set &^ takes
+	if rest == 0 {
+		return nil
+	}
+	var names []string
+	for i, name := range optionNames {
+		if rest&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return fmt.Errorf("sobel: %s doesn't take %s options: %w", fn, strings.Join(names, ", "), ErrUnsupported)
+}
+
+type options struct {
+	kernel  FilterType
+	kx, ky  *Kernel //user kernels, override kernel
+	order   int     //derivative order of Sobel kernels
+	backend string
+	mag     Magnitude
+	conv    bool //signed X kernel response instead of a magnitude
+	border  Border
+
+	mapping Mapping
+	scale   float64 //MappingScale factor
+	lo, hi  float64 //MappingRange
+
+	borderValue uint8
+
+	sigma              float64 //Gaussian pre-smoothing
+	blurRadius         int
+	box                bool    //box pre-smoothing instead
+	low, high          float64 //Canny thresholds
+	auto               bool    //Canny chooses them
+	notEdges, lowRatio float64
+
+	channels uint8 //bit i selects channel i of a ColorSpace, 0 is all
+	luma     Luma
+
+	workers  int
+	executor *Executor
+
+	set optionSet //the groups opts set, see only
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		kernel:  Sobel,
+		order:   1,
+		backend: BackendGo,
+		mag:     MagnitudeL2,
+		border:  BorderNone,
+		mapping: MappingSaturate,
+
+		auto:     true,
+		notEdges: 0.7,
+		lowRatio: 0.4,
+
+		workers:  1,
+		executor: DefaultExecutor,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithKernel selects the filter kernel, Sobel by default
+func WithKernel(flt FilterType) Option {
+	return func(o *options) { o.kernel, o.set = flt, o.set|optKernel }
+}
+
+// WithKernels selects user defined X and Y kernels instead of a
+// FilterType, their responses are combined the same way. One of them may
+// be nil for a single kernel filter.
+func WithKernels(kx, ky *Kernel) Option {
+	return func(o *options) { o.kx, o.ky, o.set = kx, ky, o.set|optKernel }
+}
+
+// WithOrder selects the derivative order (1 or 2) of Sobel filters: X and
+// Y kernels become d^n/dx^n and d^n/dy^n, 1 by default. Other derivatives
+// are available with SobelKernel and WithKernels.
+func WithOrder(n int) Option {
+	return func(o *options) { o.order, o.set = n, o.set|optKernel }
+}
+
+// WithBackend selects the implementation by the name it is registered
+// with (see RegisterBackend) or BackendFastest, BackendGo by default
+func WithBackend(name string) Option {
+	return func(o *options) { o.backend, o.set = name, o.set|optBackend }
+}
+
+// WithMagnitude selects the magnitude mode, MagnitudeL2 by default
+func WithMagnitude(m Magnitude) Option {
+	return func(o *options) { o.mag, o.set = m, o.set|optMagnitude }
+}
+
+// WithConvolution makes Apply return the response of the X kernel (the
+// one of the FilterType or kx of WithKernels) mapped and clamped to
+// 0..255 instead of a magnitude, as Convolve does. Sharpen always runs
+// this way.
+func WithConvolution() Option {
+	return func(o *options) { o.conv, o.set = true, o.set|optConvolution }
+}
+
+// WithMapping selects how magnitudes become pixels, MappingSaturate by
+// default. MappingScale and MappingRange are rather selected by WithScale
+// and WithRange, which set their parameters too.
+func WithMapping(m Mapping) Option {
+	return func(o *options) { o.mapping, o.set = m, o.set|optMapping }
+}
+
+// WithScale selects MappingScale: magnitudes are multiplied by factor
+func WithScale(factor float64) Option {
+	return func(o *options) { o.mapping, o.scale, o.set = MappingScale, factor, o.set|optMapping }
+}
+
+// WithRange selects MappingRange: magnitudes from lo to hi are mapped
+// linearly to 0..255, the ones outside are clipped
+func WithRange(lo, hi float64) Option {
+	return func(o *options) { o.mapping, o.lo, o.hi, o.set = MappingRange, lo, hi, o.set|optMapping }
+}
+
+// WithBorder selects the border policy, BorderNone by default
+func WithBorder(b Border) Option {
+	return func(o *options) { o.border, o.set = b, o.set|optBorder }
+}
+
+// WithBorderValue sets the value of pixels outside of the image for
+// BorderConstant, 0 by default
+func WithBorderValue(v uint8) Option {
+	return func(o *options) { o.borderValue, o.set = v, o.set|optBorder }
+}
+
+// WithBlur smooths the image by a Gaussian of sigma before the filter
+// runs, 0 (the default) doesn't smooth. The Gaussian is cut at 3*sigma
+// unless WithBlurRadius says otherwise.
+func WithBlur(sigma float64) Option {
+	return func(o *options) { o.sigma, o.box, o.set = sigma, false, o.set|optBlur }
+}
+
+// WithBlurRadius sets the radius of the WithBlur Gaussian, with sigma 0
+// the sigma is derived from it (see GaussianBlur)
+func WithBlurRadius(r int) Option {
+	return func(o *options) { o.blurRadius, o.set = r, o.set|optBlur }
+}
+
+// WithBoxBlur smooths the image by a box of (2r+1)² pixels before the
+// filter runs, it is faster than WithBlur for larger radii
+func WithBoxBlur(r int) Option {
+	return func(o *options) { o.sigma, o.blurRadius, o.box, o.set = 0, r, true, o.set|optBlur }
+}
+
+// WithThresholds sets the low and high hysteresis thresholds of Canny
+func WithThresholds(low, high float64) Option {
+	return func(o *options) { o.low, o.high, o.auto, o.set = low, high, false, o.set|optThresholds }
+}
+
+// WithAutoThresholds makes Canny choose the high threshold so that the
+// notEdges fraction of the pixels has a lower magnitude, and the low one
+// as lowRatio * high. It is the default with notEdges 0.7 and lowRatio
+// 0.4, as in MATLAB.
+func WithAutoThresholds(notEdges, lowRatio float64) Option {
+	return func(o *options) {
+		o.auto, o.notEdges, o.lowRatio, o.set = true, notEdges, lowRatio, o.set|optThresholds
+	}
+}
+
+// WithChannels selects the channels (0, 1 or 2) FilterChannels and
+// FilterColor filter, all by default
+func WithChannels(channels ...int) Option {
+	return func(o *options) {
+		o.channels, o.set = 0, o.set|optChannels
+		for _, c := range channels {
+			if c < 0 || c > 2 {
+				c = 3 //invalid, reported by the filter
+			}
+			o.channels |= 1 << uint(c)
+		}
+	}
+}
+
+// WithLuma selects the weights images that are not gray are converted
+// with, LumaBT601 by default
+func WithLuma(l Luma) Option {
+	return func(o *options) { o.luma, o.set = l, o.set|optLuma }
+}
+
+// WithWorkers splits the image into n row bands filtered concurrently,
+// n == 0 means as many bands as the executor runs at once. The result is
+// the same as the sequential one, which is the default (n == 1).
+func WithWorkers(n int) Option {
+	return func(o *options) { o.workers, o.set = n, o.set|optWorkers }
+}
+
+// WithExecutor selects the Executor bands are run on, DefaultExecutor
+// by default. A nil executor is an error.
+func WithExecutor(e *Executor) Option {
+	return func(o *options) { o.executor, o.set = e, o.set|optWorkers }
+}
+
+// Apply converts img to grayscale (if it is not *image.Gray already, see
+// WithLuma) and runs the filter described by opts. Every combination of options either
+// runs as requested or returns an error, nothing is substituted silently.
+// It takes the filter, backend, magnitude, convolution, mapping, border,
+// pre-smoothing, luma and workers options.
+func Apply(img image.Image, opts ...Option) (*image.Gray, error) {
+	o := newOptions(opts)
+	if err := o.only("Apply", optFilter); err != nil {
+		return nil, err
+	}
+	filtered, _, err := apply(img, &o)
+	return filtered, err
+}
+
+// apply is Apply returning the backend that ran
+func apply(img image.Image, o *options) (*image.Gray, Backend, error) {
+	if err := o.validate(); err != nil {
+		return nil, nil, err
+	}
+	b, err := o.selectBackend(func(Backend) bool { return true })
+	if err != nil {
+		return nil, nil, err
+	}
+
+	grayImg := o.gray(img)
+	filtered, err := applyBackend(b, o.smooth(grayImg), o)
+	return filtered, b, err
+}
+
+// validate checks the options that don't depend on the backend
+func (o *options) validate() error {
+	if err := o.validateKernels(); err != nil {
+		return err
+	}
+	if err := o.validateMagnitude(); err != nil {
+		return err
+	}
+	if err := o.validateConvolution(); err != nil {
+		return err
+	}
+	if err := o.validateSmooth(); err != nil {
+		return err
+	}
+	if !o.custom() && o.kernel.compass() != nil && o.mapping != MappingSaturate {
+		return fmt.Errorf("sobel: %v compass filter is always saturated, not %v", o.kernel, o.mapping)
+	}
+	if !o.border.valid() {
+		return fmt.Errorf("sobel: unknown border %v", o.border)
+	}
+	if !o.luma.valid() {
+		return fmt.Errorf("sobel: unknown luma %v", o.luma)
+	}
+	if o.workers < 0 || o.executor == nil {
+		return fmt.Errorf("sobel: invalid workers %d or executor %v", o.workers, o.executor)
+	}
+	return nil
+}
+
+// gray returns img if it is gray, otherwise img converted with the luma
+func (o *options) gray(img image.Image) *image.Gray {
+	if grayImg, ok := img.(*image.Gray); ok {
+		return grayImg
+	}
+	return ToGrayscaleLuma(img, o.luma)
+}
+
+// kernels returns the kernels selected by the options, they must be valid
+func (o *options) kernels() (kx, ky *Kernel) {
+	if o.custom() {
+		return o.kx, o.ky
+	}
+	if a := o.kernel.aperture(); a > 0 && o.order != 1 {
+		kx, ky, _ = sobelKernels(a, o.order)
+		return kx, ky
+	}
+	return o.kernel.kernels()
+}
+
+// custom reports if user kernels are selected
+func (o *options) custom() bool {
+	return o.kx != nil || o.ky != nil
+}
+
+func (o *options) validateKernels() error {
+	if !o.custom() {
+		if kx, _ := o.kernel.kernels(); kx == nil && o.kernel.compass() == nil {
+			return fmt.Errorf("sobel: unknown kernel %v", o.kernel)
+		}
+		if o.order == 1 {
+			return nil
+		}
+		if o.kernel.aperture() == 0 {
+			return fmt.Errorf("sobel: derivative order of %v kernel can't be changed", o.kernel)
+		}
+		_, _, err := sobelKernels(o.kernel.aperture(), o.order)
+		return err
+	}
+	for _, k := range []*Kernel{o.kx, o.ky} {
+		if k == nil {
+			continue
+		}
+		if err := k.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unsupported(backend, what string) error {
+	return fmt.Errorf("sobel: %s backend does not support %s: %w", backend, what, ErrUnsupported)
+}
+
+// goBackend runs every filter in go, l2 is its saturated L2 magnitude
+type goBackend struct {
+	name  string
+	speed int
+	l2    magnitudeFunc
+}
+
+func init() {
+	mustRegister(&goBackend{name: BackendGo, speed: 10, l2: magnitudeFast})
+	mustRegister(&goBackend{name: BackendMath, speed: 20, l2: magnitudeMath})
+}
+
+func (b *goBackend) Name() string {
+	return b.name
+}
+
+func (b *goBackend) Capabilities() Capabilities {
+	return Capabilities{UserKernels: true, Convolution: true, Speed: b.speed}
+}
+
+func (b *goBackend) Supports(flt FilterType) bool {
+	kx, _ := flt.kernels()
+	return kx != nil || flt.compass() != nil
+}
+
+func (b *goBackend) Gradient(img *image.Gray, f *FilterSpec, g *Gradient) error {
+	if f.Masks != nil {
+		return fmt.Errorf("sobel: %v is a compass filter, it has no gradient", f.Kernel)
+	}
+	kernelGradient(GrayPlane(img), f.KX, f.KY, g)
+	return nil
+}
+
+// Magnitude of compass filters doesn't depend on the magnitude mode,
+// there is a single response, and it is saturated
+func (b *goBackend) Magnitude(img *image.Gray, f *FilterSpec) (*image.Gray, error) {
+	switch {
+	case f.Masks != nil:
+		return filterGrayCompass(img, f.Masks, image.NewGray(img.Bounds())), nil
+	case f.Convolution:
+		return filterGrayConv(img, f.KX, f.scale, f.offset), nil
+	}
+	return filterGrayKernels(img, f.KX, f.KY, f.magnitude(b.l2)), nil
+}
+
+// kernelSize is the size of the libsimd and asm Sobel kernels
+const kernelSize = 3
+
+// simdSupports reports if libsimd and the asm backend have the kernel
+func simdSupports(flt FilterType) bool {
+	return flt == Sobel || flt == SobelFast
+}
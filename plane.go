@@ -0,0 +1,355 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Pixel is the sample type of a Plane: 8-bit, 16-bit or float32
+type Pixel interface {
+	uint8 | uint16 | float32
+}
+
+// Plane is a single channel image of any Pixel type. Like in image.Gray,
+// the value of (x, y) is at Pix[Offset(x, y)]. A Plane[uint8] has the
+// layout of image.Gray, GrayPlane and PlaneGray convert without copying.
+type Plane[T Pixel] struct {
+	Pix    []T
+	Stride int
+	Rect   image.Rectangle
+}
+
+// FloatPlane holds float32 results which are not clipped to a pixel
+// range, e.g. magnitudes of 16-bit or HDR images
+type FloatPlane = Plane[float32]
+
+// NewPlane returns a zero plane with the given bounds
+func NewPlane[T Pixel](r image.Rectangle) *Plane[T] {
+	return &Plane[T]{
+		Pix:    make([]T, r.Dx()*r.Dy()),
+		Stride: r.Dx(),
+		Rect:   r,
+	}
+}
+
+func (p *Plane[T]) Bounds() image.Rectangle {
+	return p.Rect
+}
+
+// Offset returns the index of (x, y) in Pix
+func (p *Plane[T]) Offset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x - p.Rect.Min.X)
+}
+
+// At returns the value at (x, y), zero outside of the bounds
+func (p *Plane[T]) At(x, y int) T {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return 0
+	}
+	return p.Pix[p.Offset(x, y)]
+}
+
+// Set sets the value at (x, y), points outside of the bounds are ignored
+func (p *Plane[T]) Set(x, y int, v T) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.Pix[p.Offset(x, y)] = v
+}
+
+// SubPlane returns a plane representing the portion r of p, the values
+// are shared
+func (p *Plane[T]) SubPlane(r image.Rectangle) *Plane[T] {
+	r = r.Intersect(p.Rect)
+	if r.Empty() {
+		return &Plane[T]{Stride: p.Stride}
+	}
+	return &Plane[T]{
+		Pix:    p.Pix[p.Offset(r.Min.X, r.Min.Y):],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// GrayPlane returns a plane sharing the pixels of img
+func GrayPlane(img *image.Gray) *Plane[uint8] {
+	//image.Gray Pix starts at Rect.Min like the one of a plane
+	return &Plane[uint8]{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect}
+}
+
+// PlaneGray returns an image sharing the pixels of p
+func PlaneGray(p *Plane[uint8]) *image.Gray {
+	return &image.Gray{Pix: p.Pix, Stride: p.Stride, Rect: p.Rect}
+}
+
+// Gray16Plane returns a copy of img as a plane, image.Gray16 keeps its
+// pixels as big endian bytes
+func Gray16Plane(img *image.Gray16) *Plane[uint16] {
+	b := img.Bounds()
+	p := NewPlane[uint16](b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		src := img.Pix[img.PixOffset(b.Min.X, y):]
+		dst := p.Pix[p.Offset(b.Min.X, y):][:b.Dx()]
+		for x := range dst {
+			dst[x] = uint16(src[2*x])<<8 | uint16(src[2*x+1])
+		}
+	}
+	return p
+}
+
+// PlaneGray16 returns a copy of p as an image
+func PlaneGray16(p *Plane[uint16]) *image.Gray16 {
+	b := p.Rect
+	img := image.NewGray16(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		src := p.Pix[p.Offset(b.Min.X, y):][:b.Dx()]
+		dst := img.Pix[img.PixOffset(b.Min.X, y):]
+		for x, v := range src {
+			dst[2*x], dst[2*x+1] = uint8(v>>8), uint8(v)
+		}
+	}
+	return img
+}
+
+// ApplyPlane runs the filter described by opts, as Apply does, on a
+// plane of any pixel type. The result is not clipped: it is the
+// magnitude, the strongest response of compass filters or, with
+// WithConvolution, the signed response. The mapping options are for 8
+// and 16-bit outputs, ApplyPlane returns an error for them as for the
+// luma one. Backends other than the go and math ones run the gradient of
+// Plane[uint8] only.
+func ApplyPlane[T Pixel](p *Plane[T], opts ...Option) (*FloatPlane, error) {
+	o := newOptions(opts)
+	if err := o.only("ApplyPlane", optFilter&^(optMapping|optLuma)); err != nil {
+		return nil, err
+	}
+	return applyPlane(p, &o)
+}
+
+// ApplyGradientPlane is ApplyGradient for planes of any pixel type, it
+// takes its options but the luma one
+func ApplyGradientPlane[T Pixel](p *Plane[T], opts ...Option) (*Gradient, error) {
+	o := newOptions(opts)
+	if err := o.only("ApplyGradientPlane", optGradient&^optLuma); err != nil {
+		return nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if !o.custom() && o.kernel.compass() != nil {
+		return nil, fmt.Errorf("sobel: %v is a compass filter, it has no gradient", o.kernel)
+	}
+	b, err := selectPlane[T](&o)
+	if err != nil {
+		return nil, err
+	}
+	return gradientOf(smoothPlane(p, &o), b, &o)
+}
+
+// ApplyGray16 is Apply for 16-bit images: the result is mapped the same
+// way, to 0..65535 instead of 0..255, e.g. MappingSaturate clips
+// magnitudes at 65535 and MappingNormalize maps the maximum to 65535. It
+// takes the options of Apply but the luma one.
+func ApplyGray16(img *image.Gray16, opts ...Option) (*image.Gray16, error) {
+	o := newOptions(opts)
+	if err := o.only("ApplyGray16", optFilter&^optLuma); err != nil {
+		return nil, err
+	}
+	resp, err := applyPlane(Gray16Plane(img), &o)
+	if err != nil {
+		return nil, err
+	}
+	var max float64
+	if o.mapping == MappingNormalize {
+		for _, v := range resp.Pix {
+			max = math.Max(max, float64(v))
+		}
+	}
+	scale, offset := o.linearTo(max, math.MaxUint16)
+	res := NewPlane[uint16](resp.Rect)
+	for y := resp.Rect.Min.Y; y < resp.Rect.Max.Y; y++ {
+		src := resp.Pix[resp.Offset(resp.Rect.Min.X, y):][:resp.Rect.Dx()]
+		dst := res.Pix[res.Offset(res.Rect.Min.X, y):]
+		for x, v := range src {
+			dst[x] = mapPixel16(float64(v), scale, offset)
+		}
+	}
+	return PlaneGray16(res), nil
+}
+
+// selectPlane is selectBackend for planes of T: only the go backends run
+// pixels other than 8-bit, compass filters and convolution, which have
+// float results
+func selectPlane[T Pixel](o *options) (Backend, error) {
+	var what string
+	if _, ok := any((*Plane[T])(nil)).(*Plane[uint8]); !ok {
+		what = "pixels other than 8-bit"
+	} else if !o.custom() && o.kernel.compass() != nil {
+		what = "compass filters on planes"
+	} else if o.convolution() {
+		what = "convolution on planes"
+	}
+	b, err := o.selectBackend(func(b Backend) bool {
+		return what == "" || goPlanes(b)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if what != "" && !goPlanes(b) {
+		return nil, unsupported(b.Name(), what)
+	}
+	return b, nil
+}
+
+// goPlanes reports if b is a go backend, which runs planes of any pixel
+// type with float results
+func goPlanes(b Backend) bool {
+	_, ok := b.(*goBackend)
+	return ok
+}
+
+func applyPlane[T Pixel](p *Plane[T], o *options) (*FloatPlane, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	b, err := selectPlane[T](o)
+	if err != nil {
+		return nil, err
+	}
+	p = smoothPlane(p, o)
+	if masks := o.kernel.compass(); !o.custom() && masks != nil {
+		return compassPlane(p, masks, o), nil
+	}
+	kx, _ := o.kernels()
+	if o.convolution() {
+		r := kx.Radius()
+		return withBorderPlane(p, r, o, func(img *Plane[T]) *FloatPlane {
+			res := NewPlane[float32](img.Rect)
+			planeRows(img, kx, nil, func(y int, rx, _ []float64) {
+				i := res.Offset(img.Rect.Min.X, y)
+				for x := r; x < len(rx)-r; x++ {
+					res.Pix[i+x] = float32(rx[x])
+				}
+			})
+			return res
+		}), nil
+	}
+	g, err := gradientOf(p, b, o)
+	if err != nil {
+		return nil, err
+	}
+	res := NewPlane[float32](g.Rect)
+	for y := g.Rect.Min.Y; y < g.Rect.Max.Y; y++ {
+		i := g.Offset(g.Rect.Min.X, y)
+		dst := res.Pix[res.Offset(g.Rect.Min.X, y):][:g.Rect.Dx()]
+		for x := range dst {
+			dst[x] = float32(o.mag.norm(float64(g.Dx[i+x]), float64(g.Dy[i+x])))
+		}
+	}
+	return res, nil
+}
+
+// gradientOf runs b, selectPlane checked that it runs planes of T
+func gradientOf[T Pixel](p *Plane[T], b Backend, o *options) (*Gradient, error) {
+	if goPlanes(b) {
+		return gradientPlane(p, o), nil
+	}
+	return gradientBackend(b, PlaneGray(any(p).(*Plane[uint8])), o)
+}
+
+// smoothPlane is options.smooth for planes of any pixel type, the box is
+// run as a separable kernel for pixels other than 8-bit
+func smoothPlane[T Pixel](p *Plane[T], o *options) *Plane[T] {
+	if o.sigma <= 0 && o.blurRadius <= 0 || p.Rect.Empty() {
+		return p
+	}
+	if gray, ok := any(p).(*Plane[uint8]); ok {
+		return any(GrayPlane(o.smooth(PlaneGray(gray)))).(*Plane[T])
+	}
+	k := gaussianKernel(o.sigma, o.blurRadius)
+	if o.box {
+		k = boxKernel(o.blurRadius)
+	}
+	bo := *o
+	bo.border = BorderReflect101
+	pixel := pixelFunc[T]()
+	r := k.Radius()
+	return withBorderPlane(p, r, &bo, func(img *Plane[T]) *Plane[T] {
+		res := NewPlane[T](img.Rect)
+		planeRows(img, k, nil, func(y int, rx, _ []float64) {
+			i := res.Offset(img.Rect.Min.X, y)
+			for x := r; x < len(rx)-r; x++ {
+				res.Pix[i+x] = pixel(rx[x])
+			}
+		})
+		return res
+	})
+}
+
+// compassPlane returns the strongest mask response of every pixel
+func compassPlane[T Pixel](p *Plane[T], masks []*Kernel, o *options) *FloatPlane {
+	return withBorderPlane(p, 1, o, func(img *Plane[T]) *FloatPlane {
+		res := NewPlane[float32](img.Rect)
+		convs := make([]*conv, len(masks))
+		for i, m := range masks {
+			convs[i] = m.prepare(img.Stride)
+		}
+		integral := !floatPixel[T]()
+		b := img.Rect
+		for y := b.Min.Y + 1; y < b.Max.Y-1; y++ {
+			for x := b.Min.X + 1; x < b.Max.X-1; x++ {
+				i := img.Offset(x, y)
+				best := convResponse(convs[0], img.Pix, i, integral)
+				for _, c := range convs[1:] {
+					best = math.Max(best, convResponse(c, img.Pix, i, integral))
+				}
+				res.Pix[res.Offset(x, y)] = float32(best)
+			}
+		}
+		return res
+	})
+}
+
+// floatPixel reports if T is float32, integer pixels allow integer sums
+func floatPixel[T Pixel]() bool {
+	var v T
+	_, ok := any(v).(float32)
+	return ok
+}
+
+// pixelMax returns the largest value of T, +Inf for float32
+func pixelMax[T Pixel]() float64 {
+	var v T
+	switch any(v).(type) {
+	case uint8:
+		return math.MaxUint8
+	case uint16:
+		return math.MaxUint16
+	}
+	return math.Inf(1)
+}
+
+// pixelFunc returns the conversion of responses to T: integer pixels are
+// rounded and clipped, float32 ones are kept as they are
+func pixelFunc[T Pixel]() func(v float64) T {
+	var v T
+	switch any(v).(type) {
+	case uint8:
+		return func(v float64) T { return T(mapPixel(v, 1, 0)) }
+	case uint16:
+		return func(v float64) T { return T(mapPixel16(v, 1, 0)) }
+	}
+	return func(v float64) T { return T(v) }
+}
+
+// mapPixel16 is mapPixel for 16-bit pixels
+func mapPixel16(v, scale, offset float64) uint16 {
+	v = v*scale + offset + 0.5
+	if v >= math.MaxUint16 {
+		return math.MaxUint16
+	} else if v < 0 || v != v {
+		return 0
+	}
+	return uint16(v)
+}
@@ -1,3 +1,5 @@
+//go:build cgo && libsimd
+
 #include <stdlib.h>
 #include <math.h>
 #include "Simd/SimdLib.h"
@@ -5,27 +7,26 @@
 #define TRUE 1
 #define FALSE 0
 
-int  sobelSimdGray8(uint8_t *src, size_t width, size_t height, uint8_t *dst) {
+int  sobelSimdGray8(uint8_t *src, size_t srcStride, size_t width, size_t height, uint8_t *dst) {
     size_t  imgSize = width * height ;
-	size_t srcStride = width ;
-    size_t dstStride = srcStride * 2 ;
+    size_t dstStride = width * 2 ;
     size_t dstSize = imgSize * 2 ;
 
     uint8_t * dstXC = malloc(dstSize) ;
     if (NULL == dstXC ) {
         return FALSE ;
     }
-    uint8_t *dstX = dstXC ;
+    uint16_t *dstX = (uint16_t *)dstXC ;
 
     uint8_t * dstYC = malloc(dstSize) ;
     if (NULL == dstYC ) {
         free(dstXC) ;
         return FALSE ;
     }
-    uint8_t *dstY = dstYC ;
+    uint16_t *dstY = (uint16_t *)dstYC ;
 
-    SimdSobelDxAbs(src, srcStride, width, height, dstX, dstStride) ;
-    SimdSobelDyAbs(src, srcStride, width, height, dstY, dstStride) ;
+    SimdSobelDxAbs(src, srcStride, width, height, dstXC, dstStride) ;
+    SimdSobelDyAbs(src, srcStride, width, height, dstYC, dstStride) ;
 
     uint32_t fX, fY ;
     double  fS ;
@@ -33,9 +34,10 @@ int  sobelSimdGray8(uint8_t *src, size_t width, size_t height, uint8_t *dst) {
     for (int i = 0; i < imgSize; i++ ) {
         fX = (uint32_t)*dstX ;
         fY = (uint32_t)*dstY ;
-        fS = sqrt((double)(fX*fX + fY*fY));
+        //rounded to nearest like the go backends
+        fS = sqrt((double)(fX*fX + fY*fY)) + 0.5;
         //clipping
-        if (fS > 255.0) {
+        if (fS >= 255.0) {
             pix = 255 ;
         } else {
             pix = (uint8_t)fS ;
@@ -0,0 +1,40 @@
+//go:build cgo && libsimd
+
+package sobel
+
+import (
+	"errors"
+	"testing"
+)
+
+func (s *SobelTS) Benchmark_FilterGraySimd(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		FilterGraySimd(s.img)
+	}
+}
+
+func (s *SobelTS) Benchmark_FilterGraySimdC(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		FilterGraySimdC(s.img)
+	}
+}
+
+func (s *SobelTS) Benchmark_SqrtFloorC(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		FloorSqrtC(sqrtFrom)
+	}
+}
+
+func (s *SobelTS) Test_FilterGraySimd(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		FilterGraySimd(s.img)
+	} //
+	if _, backend := FilterSimdBackend(s.img, Sobel); backend != BackendSimd {
+		t.Errorf("FilterSimd ran %s, expected %s", backend, BackendSimd)
+	}
+	for _, opt := range []Option{WithKernel(Sobel5), WithKernels(sobelX, sobelY), WithConvolution()} {
+		if _, err := Apply(s.img, opt, WithBackend(BackendSimd)); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("simd backend accepted %v: %v", newOptions([]Option{opt}).kernel, err)
+		}
+	}
+}
@@ -1,16 +1,17 @@
+//go:build cgo && libsimd
+
 package sobel
 
 /*
 #cgo pkg-config: libsimd
 #include <stdlib.h>
 #include "Simd/SimdLib.h"
-extern int  sobelSimdGray8(uint8_t *src, size_t width, size_t height, uint8_t *dst)  ;
+extern int  sobelSimdGray8(uint8_t *src, size_t srcStride, size_t width, size_t height, uint8_t *dst)  ;
 */
 import "C"
 
 import (
 	"image"
-	"math"
 	"reflect"
 	"unsafe"
 )
@@ -22,11 +23,70 @@ import (
 //
 //BenchmarkIT/Benchmark_FilterGraySimd-2         	     276	   4853208 ns/op
 //add flited filling
-func FilterGraySimd(grayImg *image.Gray) (filtered *image.Gray) {
-	max := grayImg.Bounds().Max
-	min := grayImg.Bounds().Min
-	filtered = image.NewGray(image.Rect(max.X, max.Y, min.X, min.Y))
-	imSize := len(grayImg.Pix)
+func FilterGraySimd(grayImg *image.Gray, opts ...Option) *image.Gray {
+	o, own := legacyOptions("FilterGraySimd", Sobel, opts)
+	if !own {
+		filtered, _ := applyLegacy(grayImg, &o, BackendSimd)
+		return filtered
+	}
+	return filterGraySimdBorder(grayImg, &o)
+}
+
+// filterGraySimdBorder is the code of FilterGraySimd
+func filterGraySimdBorder(grayImg *image.Gray, o *options) *image.Gray {
+	return withBorder(o.smooth(grayImg), kernelSize/2, o, func(img *image.Gray) *image.Gray {
+		return filterGraySimdFrame(img, magnitudeMath)
+	})
+}
+
+// filterGraySimdFrame is filterGraySimd with the border dropped
+// to be consistent with go filters, libsimd computes it too
+func filterGraySimdFrame(grayImg *image.Gray, mag magnitudeFunc) *image.Gray {
+	filtered := filterGraySimd(grayImg, mag)
+	clearFrame(filtered, kernelSize/2)
+	return filtered
+}
+
+// filterSimd runs the code of FilterGraySimd for FilterSimd
+func filterSimd(grayImg *image.Gray, o *options) (*image.Gray, string) {
+	return filterGraySimdBorder(grayImg, o), BackendSimd
+}
+
+// simdBackend runs 3x3 Sobel with libsimd
+type simdBackend struct{}
+
+func init() {
+	mustRegister(simdBackend{})
+}
+
+func (simdBackend) Name() string {
+	return BackendSimd
+}
+
+func (simdBackend) Capabilities() Capabilities {
+	return Capabilities{Speed: 30}
+}
+
+func (simdBackend) Supports(flt FilterType) bool {
+	return simdSupports(flt)
+}
+
+func (b simdBackend) Magnitude(img *image.Gray, f *FilterSpec) (*image.Gray, error) {
+	if err := f.check(b); err != nil {
+		return nil, err
+	}
+	return filterGraySimdFrame(img, f.magnitude(magnitudeMath)), nil
+}
+
+func filterGraySimd(grayImg *image.Gray, mag magnitudeFunc) (filtered *image.Gray) {
+	filtered = image.NewGray(grayImg.Bounds())
+	//grayImg may be a sub-image: Pix starts at Bounds().Min and rows
+	//are Stride bytes apart, the result is always packed
+	w, h := grayImg.Bounds().Dx(), grayImg.Bounds().Dy()
+	imSize := w * h
+	if imSize == 0 {
+		return filtered
+	}
 
 	// void SimdSobelDyAbs (
 	//    [in]	src	- a pointer to pixels data of the input image.
@@ -40,9 +100,9 @@ func FilterGraySimd(grayImg *image.Gray) (filtered *image.Gray) {
 	// must has 8-bit gray format, output image must has 16-bit integer format.
 	src := (*C.uint8_t)(unsafe.Pointer(&grayImg.Pix[0]))
 	srcStride := C.size_t(grayImg.Stride)
-	width := C.size_t(max.X)
-	height := C.size_t(max.Y)
-	dstStride := srcStride * 2
+	width := C.size_t(w)
+	height := C.size_t(h)
+	dstStride := width * 2
 	dstSize := C.size_t(imSize * 2)
 
 	dstXC := (*C.uint8_t)(unsafe.Pointer(C.malloc(dstSize)))
@@ -57,21 +117,9 @@ func FilterGraySimd(grayImg *image.Gray) (filtered *image.Gray) {
 	dstX := nonCopyGoUint16(uintptr(unsafe.Pointer(dstXC)), imSize)
 	dstY := nonCopyGoUint16(uintptr(unsafe.Pointer(dstYC)), imSize)
 
-	var fX, fY uint
-	var fS float64
-	var pix uint8
 	if len(dstX) == imSize && len(dstY) == imSize && len(filtered.Pix) == imSize {
 		for i := 0; i < imSize; i++ {
-			fX = uint(dstX[i])
-			fY = uint(dstY[i])
-			fS = math.Sqrt(float64(fX*fX + fY*fY))
-			//clipping
-			if fS > 255.0 {
-				pix = 255
-			} else {
-				pix = uint8(fS)
-			}
-			filtered.Pix[i] = pix
+			filtered.Pix[i] = mag(uint32(dstX[i]), uint32(dstY[i]))
 		}
 	}
 	return filtered
@@ -86,15 +134,59 @@ func nonCopyGoUint16(ptr uintptr, length int) []uint16 {
 	return slice
 }
 
-func FilterGraySimdC(grayImg *image.Gray) (filtered *image.Gray) {
-	max := grayImg.Bounds().Max
-	min := grayImg.Bounds().Min
-	filtered = image.NewGray(image.Rect(max.X, max.Y, min.X, min.Y))
+// FilterGraySimdC is FilterGraySimd with the Sobel loop of simd.c, the
+// options other than border, pre-smoothing and workers run the simd
+// backend
+func FilterGraySimdC(grayImg *image.Gray, opts ...Option) *image.Gray {
+	o, own := legacyOptions("FilterGraySimdC", Sobel, opts)
+	if !own {
+		filtered, _ := applyLegacy(grayImg, &o, BackendSimd)
+		return filtered
+	}
+	return withBorder(o.smooth(grayImg), kernelSize/2, &o, filterGraySimdC)
+}
+
+func filterGraySimdC(grayImg *image.Gray) (filtered *image.Gray) {
+	filtered = image.NewGray(grayImg.Bounds())
+	if len(filtered.Pix) == 0 {
+		return filtered
+	}
 	src := (*C.uint8_t)(unsafe.Pointer(&grayImg.Pix[0]))
+	srcStride := C.size_t(grayImg.Stride)
 	dst := (*C.uint8_t)(unsafe.Pointer(&filtered.Pix[0]))
-	width := C.size_t(max.X)
-	height := C.size_t(max.Y)
-	C.sobelSimdGray8(src, width, height, dst)
+	width := C.size_t(grayImg.Bounds().Dx())
+	height := C.size_t(grayImg.Bounds().Dy())
+	C.sobelSimdGray8(src, srcStride, width, height, dst)
+	clearFrame(filtered, kernelSize/2)
 
 	return filtered
 }
+
+// Gradient computes signed Sobel responses with SimdSobelDx and
+// SimdSobelDy, which produce int16 values
+func (sb simdBackend) Gradient(img *image.Gray, f *FilterSpec, g *Gradient) error {
+	if err := f.check(sb); err != nil {
+		return err
+	}
+	r := kernelSize / 2
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= 2*r || h <= 2*r {
+		return nil
+	}
+	src := (*C.uint8_t)(unsafe.Pointer(&img.Pix[0]))
+	dx := make([]int16, w*h)
+	dy := make([]int16, w*h)
+	C.SimdSobelDx(src, C.size_t(img.Stride), C.size_t(w), C.size_t(h), (*C.uint8_t)(unsafe.Pointer(&dx[0])), C.size_t(w*2))
+	C.SimdSobelDy(src, C.size_t(img.Stride), C.size_t(w), C.size_t(h), (*C.uint8_t)(unsafe.Pointer(&dy[0])), C.size_t(w*2))
+	//libsimd replicates the border, only the complete neighbourhoods
+	//are kept like in the other backends
+	for y := r; y < h-r; y++ {
+		i := g.Offset(b.Min.X, b.Min.Y+y)
+		for x := r; x < w-r; x++ {
+			g.Dx[i+x] = float32(dx[y*w+x])
+			g.Dy[i+x] = float32(dy[y*w+x])
+		}
+	}
+	return nil
+}
@@ -1,310 +1,309 @@
+// Package sobel implements Sobel and a few other edge detection filters,
+// as well as user defined convolution kernels, with pure go, go assembly
+// and libsimd based backends.
+//
+// The package builds with the go toolchain alone. The libsimd backend
+// (BackendSimd) needs cgo and libsimd installed for pkg-config, it is
+// built with the libsimd tag:
+//
+//	go build -tags libsimd
+//
+// Without it FilterGraySimd and FilterGraySimdC run FilterGrayAsm and
+// FloorSqrtC is FloorSqrt.
+//
+// Backends implement the Backend interface and register themselves, other
+// packages can add their own with RegisterBackend. WithBackend selects one
+// by name or the fastest one that runs the filter (BackendFastest).
+//
+// Output geometry is the same for every backend: the filtered image has
+// exactly the bounds of the input image, and pixel (x, y) of the output is
+// the filter response centred on pixel (x, y) of the input. Pixels closer
+// to the edge than the kernel radius have no complete neighbourhood, they
+// are left 0 unless a border policy (see WithBorder) says otherwise;
+// BorderCrop drops them, so the output bounds are the input bounds inset
+// by the radius, still in the coordinates of the input.
 package sobel
 
 import (
+	"fmt"
 	"image"
-	"image/color"
-	"math"
 )
 
 var (
-	sobelX = [3][3]int{
-		{-1, 0, 1},
-		{-2, 0, 2},
-		{-1, 0, 1},
-	}
-
-	sobelY = [3][3]int{
-		{-1, -2, -1},
-		{0, 0, 0},
-		{1, 2, 1},
-	}
-
-	sobelXF = [9]float64{
-		-1, 0, 1,
-		-2, 0, 2,
-		-1, 0, 1,
-	}
-
-	sobelYF = [9]float64{
-		-1, -2, -1,
+	sobelX = mustSobelKernel(3, 1, 0)
+	sobelY = mustSobelKernel(3, 0, 1)
+
+	sobel5X = mustSobelKernel(5, 1, 0)
+	sobel5Y = mustSobelKernel(5, 0, 1)
+
+	sobel7X = mustSobelKernel(7, 1, 0)
+	sobel7Y = mustSobelKernel(7, 0, 1)
+
+	sharaX = &Kernel{Size: 3, Weights: []float64{
+		-3, 0, 3,
+		-10, 0, 10,
+		-3, 0, 3,
+	}}
+	sharaY = &Kernel{Size: 3, Weights: []float64{
+		-3, -10, -3,
 		0, 0, 0,
-		1, 2, 1,
-	}
+		3, 10, 3,
+	}}
 
-	sobelXL = [9]int{
+	prewittX = &Kernel{Size: 3, Weights: []float64{
 		-1, 0, 1,
-		-2, 0, 2,
 		-1, 0, 1,
-	}
-
-	sobelYL = [9]int{
-		-1, -2, -1,
+		-1, 0, 1,
+	}}
+	prewittY = &Kernel{Size: 3, Weights: []float64{
+		-1, -1, -1,
 		0, 0, 0,
-		1, 2, 1,
-	}
-
-	sharaX = [3][3]int{
-		{-3, 0, 3},
-		{-10, 0, 10},
-		{-3, 0, 3},
-	}
-	sharaY = [3][3]int{
-		{3, 10, 3},
-		{0, 0, 0},
-		{-3, -10, -3},
-	}
-
-	laplasianX = [3][3]int{
-		{1, 1, 1},
-		{1, -8, 1},
-		{1, 1, 1},
-	}
-	laplasianY = [3][3]int{
-		{1, 1, 1},
-		{1, -8, 1},
-		{1, 1, 1},
-	}
+		1, 1, 1,
+	}}
 
-	sharpenX = [3][3]int{
-		{0, -1, 0},
-		{-1, 5, -1},
-		{0, -1, 0},
-	}
-	sharpenY = [3][3]int{
-		{0, -1, 0},
-		{-1, 5, -1},
-		{0, -1, 0},
-	}
+	//2x2 Roberts cross kernels anchored at their top left pixel
+	robertsX = &Kernel{Size: 3, Weights: []float64{
+		0, 0, 0,
+		0, 1, 0,
+		0, 0, -1,
+	}}
+	robertsY = &Kernel{Size: 3, Weights: []float64{
+		0, 0, 0,
+		0, 0, 1,
+		0, -1, 0,
+	}}
+
+	laplasianX = &Kernel{Size: 3, Weights: []float64{
+		1, 1, 1,
+		1, -8, 1,
+		1, 1, 1,
+	}}
+
+	sharpenX = &Kernel{Size: 3, Weights: []float64{
+		0, -1, 0,
+		-1, 5, -1,
+		0, -1, 0,
+	}}
 )
 
 type FilterType int
 
-const kernelSize = 3
-
 const (
 	Sobel FilterType = iota
 	SobelFast
-	Laplasian
+	Laplasian //|Laplacian|, see Laplacian for the signed response
 	Shara
-	Sharpen
+	Sharpen //an image filter, see WithConvolution
+	Sobel5 //5x5 aperture
+	Sobel7 //7x7 aperture
+	Prewitt
+	Roberts
+	Kirsch   //compass, see Compass
+	Robinson //compass, see Compass
+
+	Scharr = Shara
 )
 
-type filterFunc func(img *image.Gray, x int, y int) (uint32, uint32)
-
-func Filter(img image.Image, flt FilterType) *image.Gray {
-	grayImg := ToGrayscale(img)
-	return FilterGrayFast(grayImg, flt)
-}
-
-func FilterMath(img image.Image, flt FilterType) *image.Gray {
-	grayImg := ToGrayscale(img)
-	return FilterGrayMath(grayImg)
-}
-
-func FilterSimd(img image.Image, flt FilterType) *image.Gray {
-	grayImg := ToGrayscale(img)
-	return FilterGraySimd(grayImg)
-}
-
-func getFilterFunc(flt FilterType) (res filterFunc) {
+func (flt FilterType) String() string {
 	switch flt {
 	case Sobel:
-		res = applySobelFilter
-		break
+		return "Sobel"
 	case SobelFast:
-		res = applySobelFilterFast
-		break
+		return "SobelFast"
 	case Laplasian:
-		res = applyLaplasianFilter
-		break
+		return "Laplasian"
 	case Shara:
-		res = applySharaFilter
-		break
+		return "Shara"
 	case Sharpen:
-		res = applySharpenFilter
+		return "Sharpen"
+	case Sobel5:
+		return "Sobel5"
+	case Sobel7:
+		return "Sobel7"
+	case Prewitt:
+		return "Prewitt"
+	case Roberts:
+		return "Roberts"
+	case Kirsch:
+		return "Kirsch"
+	case Robinson:
+		return "Robinson"
 	}
-	return res
+	return fmt.Sprintf("FilterType(%d)", int(flt))
 }
 
-//for better optimization in case of input gray image
-func FilterGray(grayImg *image.Gray, flt FilterType) (filtered *image.Gray) {
-	max := grayImg.Bounds().Max
-	min := grayImg.Bounds().Min
-	/* filtered image must be two pixels shorter, because
-	there must be a row of pixels on each side of a pixel for the sobel operator
-	to work*/
-	filtered = image.NewGray(image.Rect(max.X-2, max.Y-2, min.X, min.Y))
-	width := max.X - 1 //to provide 1 pixel "border"
-	height := max.Y - 1
-	applay := getFilterFunc(flt)
-	for x := 1; x < width; x++ {
-		for y := 1; y < height; y++ {
-			fX, fY := applay(grayImg, x, y)
-			v := ISqrt((fX*fX)+(fY*fY)) + 1 // +1 to make it ceil
-			pixel := color.Gray{Y: uint8(v)}
-			filtered.SetGray(x, y, pixel)
-		}
+// Filter runs the filter flt on img converted to gray, as FilterGrayFast.
+//
+// Filter* and FilterGray* functions predate Apply and have no error
+// result. They run their own code for the border, pre-smoothing and
+// workers options, with any other option of Apply they run Apply with
+// the kernel flt (Sobel for the ones without flt) and their backend,
+// which the options may override. A backend that doesn't run the
+// filter, e.g. asm with WithKernels, is replaced by BackendGo. They
+// panic where Apply returns an error: for invalid option values, options
+// Apply doesn't take and backends the options select that don't run the
+// filter.
+func Filter(img image.Image, flt FilterType, opts ...Option) *image.Gray {
+	o, own := legacyOptions("Filter", flt, opts)
+	if !own {
+		filtered, _ := applyLegacy(img, &o, BackendGo)
+		return filtered
 	}
-	return filtered
+	return filterGrayBorder(ToGrayscale(img), flt, magnitudeFast, &o)
 }
 
-//Benchmark_FilterGray	   27336411 ns/op
-//Benchmark_FilterGrayFast 19521755 ns/op
-//for better optimization in case of input gray image
-func FilterGrayFast(grayImg *image.Gray, flt FilterType) (filtered *image.Gray) {
-	max := grayImg.Bounds().Max
-	min := grayImg.Bounds().Min
-
-	/* filtered image must be two pixels shorter, because
-	there must be a row of pixels on each side of a pixel for the sobel operator
-	to work*/
-	filtered = image.NewGray(image.Rect(max.X-2, max.Y-2, min.X, min.Y))
-	width := max.X - 1 //to provide a "border" of 1 pixel
-	height := max.Y - 1
-
-	var v uint32
-	applay := getFilterFunc(flt)
-
-	for x := 1; x < width; x++ {
-		for y := 1; y < height; y++ {
-			fX, fY := applay(grayImg, x, y)
-			v = FloorSqrt((fX*fX)+(fY*fY)) + 1 // +1 to make it ceil
-			filtered.SetGray(x, y, color.Gray{Y: uint8(v)})
-		}
+func FilterMath(img image.Image, flt FilterType, opts ...Option) *image.Gray {
+	o, own := legacyOptions("FilterMath", flt, opts)
+	if !own {
+		filtered, _ := applyLegacy(img, &o, BackendMath)
+		return filtered
 	}
+	return filterGrayBorder(ToGrayscale(img), flt, magnitudeMath, &o)
+}
 
+// FilterSimd runs the Simd backend for the kernels libsimd implements
+// and falls back to FilterGrayFast for the rest. Without libsimd (see the
+// libsimd build tag) FilterGrayAsm runs instead of the Simd backend.
+func FilterSimd(img image.Image, flt FilterType, opts ...Option) *image.Gray {
+	filtered, _ := filterSimdBackend("FilterSimd", img, flt, opts)
 	return filtered
 }
 
-func FilterGrayMath(grayImg *image.Gray) (filtered *image.Gray) {
-	max := grayImg.Bounds().Max
-	min := grayImg.Bounds().Min
-
-	/* filtered image must be two pixels shorter, because
-	there must be a row of pixels on each side of a pixel for the sobel operator
-	to work*/
-	filtered = image.NewGray(image.Rect(max.X-2, max.Y-2, min.X, min.Y))
-	width := max.X - 1 //to provide a "border" of 1 pixel
-	height := max.Y - 1
+// FilterSimdBackend is FilterSimd returning the name of the backend that
+// ran: BackendSimd, BackendAsm or BackendGo, or the one the options select
+func FilterSimdBackend(img image.Image, flt FilterType, opts ...Option) (*image.Gray, string) {
+	return filterSimdBackend("FilterSimdBackend", img, flt, opts)
+}
 
-	for x := 1; x < width; x++ {
-		for y := 1; y < height; y++ {
-			fX, fY := applySobelFilterMath(grayImg, x, y)
-			fS := (fX * fX) + (fY * fY)
-			//filtered.SetGray(x, y, color.Gray{Y: uint8(v)})
-			filtered.Pix[filtered.PixOffset(x-1, y-1)] = uint8(math.Sqrt(fS))
-		}
+func filterSimdBackend(fn string, img image.Image, flt FilterType, opts []Option) (*image.Gray, string) {
+	o, own := legacyOptions(fn, flt, opts)
+	if !own {
+		return applyLegacy(img, &o, BackendSimd, BackendAsm)
+	}
+	grayImg := ToGrayscale(img)
+	if !simdSupports(flt) {
+		return filterGrayBorder(grayImg, flt, magnitudeFast, &o), BackendGo
 	}
+	return filterSimd(grayImg, &o)
+}
 
-	return filtered
+// legacyOptions returns the options of the Filter* or FilterGray*
+// function fn and if its own code runs them, it panics for invalid ones
+func legacyOptions(fn string, flt FilterType, opts []Option) (o options, own bool) {
+	o = newOptions(opts)
+	if o.set&optKernel == 0 {
+		o.kernel = flt
+	}
+	err := o.only(fn, optFilter)
+	if err == nil {
+		err = o.validate()
+	}
+	if err != nil {
+		panic(err)
+	}
+	return o, o.set&^(optBorder|optBlur|optWorkers) == 0
 }
 
-func applyLaplasianFilter(img *image.Gray, x int, y int) (uint32, uint32) {
-	var fX, fY int
-	curX := x - 1
-	curY := y - 1
-	for i := 0; i < 3; i++ {
-		for j := 0; j < 3; j++ {
-			pixel := int(img.GrayAt(curX, curY).Y)
-			fX += laplasianX[i][j] * pixel
-			fY += laplasianY[i][j] * pixel
-			curX++
+// applyLegacy runs Apply for a Filter* or FilterGray* function, on the
+// first of backends that runs the filter unless the options select one
+func applyLegacy(img image.Image, o *options, backends ...string) (*image.Gray, string) {
+	if o.set&optBackend == 0 {
+		o.backend = BackendGo
+		f := o.filter()
+		for _, name := range backends {
+			if b, err := LookupBackend(name); err == nil && f.check(b) == nil {
+				o.backend = name
+				break
+			}
 		}
-		curX = x - 1
-		curY++
 	}
-	return Abs(fX), Abs(fY)
+	filtered, b, err := apply(img, o)
+	if err != nil {
+		panic(err)
+	}
+	return filtered, b.Name()
 }
 
-func applySobelFilter(img *image.Gray, x int, y int) (uint32, uint32) {
-	var fX, fY, pixel int
-	curX := x - 1
-	curY := y - 1
-	for i := 0; i < 3; i++ {
-		for j := 0; j < 3; j++ {
-			pixel = int(img.GrayAt(curX, curY).Y)
-			fX += sobelX[i][j] * pixel
-			fY += sobelY[i][j] * pixel
-			curX++
-		}
-		curX = x - 1
-		curY++
+// kernels returns X and Y kernels of the filter, nil for unknown filters
+func (flt FilterType) kernels() (kx, ky *Kernel) {
+	switch flt {
+	case Sobel, SobelFast:
+		return sobelX, sobelY
+	case Laplasian:
+		return laplasianX, nil
+	case Shara:
+		return sharaX, sharaY
+	case Sharpen:
+		return sharpenX, nil
+	case Sobel5:
+		return sobel5X, sobel5Y
+	case Sobel7:
+		return sobel7X, sobel7Y
+	case Prewitt:
+		return prewittX, prewittY
+	case Roberts:
+		return robertsX, robertsY
 	}
-	return Abs(fX), Abs(fY)
+	return nil, nil
 }
 
-//Benchmark_applySobelFilter	   	33.8 ns/op
-//Benchmark_applySobelFilterFast   	19.0 ns/op
-func applySobelFilterFast(img *image.Gray, x int, y int) (uint32, uint32) {
-	var fX, fY, pixel, index int
-	curX := x - 1
-	curY := y - 1
-	for i := 0; i < kernelSize; i++ {
-		//index = i * kernelSize
-		for j := 0; j < kernelSize; j++ {
-			//it is unsafe but faster on 10% or so
-			pixel = int(img.Pix[img.PixOffset(curX, curY)])
-			fX += sobelXL[index] * pixel
-			fY += sobelYL[index] * pixel
-			curX++
-			index++
-		}
-		curX = x - 1
-		curY++
+// aperture returns the kernel size of Sobel filters, 0 for the others
+func (flt FilterType) aperture() int {
+	switch flt {
+	case Sobel, SobelFast:
+		return 3
+	case Sobel5:
+		return 5
+	case Sobel7:
+		return 7
 	}
-	return Abs(fX), Abs(fY)
+	return 0
 }
 
-func applySobelFilterMath(img *image.Gray, x int, y int) (float64, float64) {
-	var fX, fY, pixel, index int
-	curX := x - 1
-	curY := y - 1
-	for i := 0; i < kernelSize; i++ {
-		//index = i * kernelSize
-		for j := 0; j < kernelSize; j++ {
-			//it is unsafe but faster on 10% or so
-			pixel = int(img.Pix[img.PixOffset(curX, curY)])
-			fX += sobelXL[index] * pixel
-			fY += sobelYL[index] * pixel
-			curX++
-			index++
-		}
-		curX = x - 1
-		curY++
+//for better optimization in case of input gray image
+func FilterGray(grayImg *image.Gray, flt FilterType, opts ...Option) *image.Gray {
+	o, own := legacyOptions("FilterGray", flt, opts)
+	if !own {
+		filtered, _ := applyLegacy(grayImg, &o, BackendGo)
+		return filtered
 	}
-	return math.Abs(float64(fX)), math.Abs(float64(fY))
+	return filterGrayBorder(grayImg, flt, magnitudeISqrt, &o)
 }
 
-func applySharaFilter(img *image.Gray, x int, y int) (uint32, uint32) {
-	var fX, fY int
-	curX := x - 1
-	curY := y - 1
-	for i := 0; i < 3; i++ {
-		for j := 0; j < 3; j++ {
-			pixel := int(img.GrayAt(curX, curY).Y)
-			fX += sharaX[i][j] * pixel
-			fY += sharaY[i][j] * pixel
-			curX++
-		}
-		curX = x - 1
-		curY++
+//Benchmark_FilterGray	   27336411 ns/op
+//Benchmark_FilterGrayFast 19521755 ns/op
+//for better optimization in case of input gray image
+func FilterGrayFast(grayImg *image.Gray, flt FilterType, opts ...Option) *image.Gray {
+	o, own := legacyOptions("FilterGrayFast", flt, opts)
+	if !own {
+		filtered, _ := applyLegacy(grayImg, &o, BackendGo)
+		return filtered
 	}
-	return Abs(fX), Abs(fY)
+	return filterGrayBorder(grayImg, flt, magnitudeFast, &o)
 }
 
-func applySharpenFilter(img *image.Gray, x int, y int) (uint32, uint32) {
-	var fX, fY int
-	curX := x - 1
-	curY := y - 1
-	for i := 0; i < 3; i++ {
-		for j := 0; j < 3; j++ {
-			pixel := int(img.GrayAt(curX, curY).Y)
-			fX += sharpenX[i][j] * pixel
-			fY += sharpenY[i][j] * pixel
-			curX++
-		}
-		curX = x - 1
-		curY++
+func FilterGrayMath(grayImg *image.Gray, opts ...Option) *image.Gray {
+	o, own := legacyOptions("FilterGrayMath", Sobel, opts)
+	if !own {
+		filtered, _ := applyLegacy(grayImg, &o, BackendMath)
+		return filtered
+	}
+	return filterGrayBorder(grayImg, Sobel, magnitudeMath, &o)
+}
+
+// filterGrayBorder is the code of the Filter* functions, it takes the
+// border, pre-smoothing and workers options
+func filterGrayBorder(grayImg *image.Gray, flt FilterType, mag magnitudeFunc, o *options) *image.Gray {
+	grayImg = o.smooth(grayImg)
+	if masks := flt.compass(); masks != nil {
+		filtered, _ := compassBorder(grayImg, masks, o)
+		return filtered
+	}
+	kx, ky := flt.kernels()
+	if flt == Sharpen {
+		return convolveBorder(grayImg, kx, o)
 	}
-	return Abs(fX), Abs(fY)
+	return withBorder(grayImg, kernelsRadius(kx, ky), o, func(img *image.Gray) *image.Gray {
+		return filterGrayKernels(img, kx, ky, mag)
+	})
 }
@@ -1,13 +1,23 @@
 package sobel
 
 import (
+	"bytes"
+	"errors"
 	"image"
+	"image/color"
 	"image/png"
 	"log"
 	"math"
+	"math/rand"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/bksworm/sobel/internal/asm"
 	"github.com/bksworm/sobel/testsuite"
 )
 
@@ -17,7 +27,7 @@ type SobelTS struct {
 	img   *image.Gray
 }
 
-const fileName = "../img/test.png"
+const fileName = "testdata/test.png"
 
 func decodePng(filename string) (image.Image, error) {
 	f, err := os.Open(filename)
@@ -63,27 +73,47 @@ func BenchmarkIT(b *testing.B) {
 
 //THERE ARE TEST
 
-func (s *SobelTS) Benchmark_applySobelFilter(b *testing.B) {
+func (s *SobelTS) Benchmark_convResponse(b *testing.B) {
+	cx := sobelX.prepare(s.img.Stride)
+	i := s.img.PixOffset(7, 7)
+	for n := 0; n < b.N; n++ {
+		cx.response(s.img.Pix, i)
+	}
+}
+
+func (s *SobelTS) Benchmark_FilterGray(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		applySobelFilter(s.img, 7, 7)
+		FilterGray(s.img, SobelFast)
 	}
 }
 
-func (s *SobelTS) Benchmark_applySobelFilterFast(b *testing.B) {
+func (s *SobelTS) Benchmark_FilterGrayFast(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		applySobelFilterFast(s.img, 7, 7)
+		FilterGrayFast(s.img, SobelFast)
 	}
 }
 
-func (s *SobelTS) Benchmark_FilterGray(b *testing.B) {
+func (s *SobelTS) Benchmark_ApplyWorkers(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		FilterGray(s.img, SobelFast)
+		Apply(s.img, WithWorkers(0))
 	}
 }
 
-func (s *SobelTS) Benchmark_FilterGrayFast(b *testing.B) {
+func (s *SobelTS) Benchmark_Kernel7x7(b *testing.B) {
+	row := []float64{1, 6, 15, 20, 15, 6, 1}
+	col := []float64{-1, -4, -5, 0, 5, 4, 1}
+	k, _ := NewSeparableKernel(row, col)
 	for i := 0; i < b.N; i++ {
-		FilterGrayFast(s.img, SobelFast)
+		filterGrayKernels(s.img, k, nil, magnitudeMath)
+	}
+}
+
+func (s *SobelTS) Benchmark_Kernel7x7OnePass(b *testing.B) {
+	row := []float64{1, 6, 15, 20, 15, 6, 1}
+	col := []float64{-1, -4, -5, 0, 5, 4, 1}
+	k, _ := NewSeparableKernel(row, col)
+	for i := 0; i < b.N; i++ {
+		directRows(GrayPlane(s.img), k, nil, func(y int, rx, ry []float64) {})
 	}
 }
 
@@ -93,16 +123,43 @@ func (s *SobelTS) Benchmark_FilterGrayMath(b *testing.B) {
 	}
 }
 
-func (s *SobelTS) Benchmark_FilterGraySimd(b *testing.B) {
+func (s *SobelTS) Benchmark_FilterGrayAsm(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		FilterGraySimd(s.img)
+		FilterGrayAsm(s.img)
 	}
 }
 
-func (s *SobelTS) Benchmark_FilterGraySimdC(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		FilterGraySimdC(s.img)
+// sobelFilters are the FilterGray* functions of 3x3 Sobel with the L2
+// magnitude, the Simd ones run FilterGrayAsm without libsimd
+var sobelFilters = map[string]func(*image.Gray, ...Option) *image.Gray{
+	"FilterGrayMath":  FilterGrayMath,
+	"FilterGrayAsm":   FilterGrayAsm,
+	"FilterGraySimd":  FilterGraySimd,
+	"FilterGraySimdC": FilterGraySimdC,
+}
+
+// testBackends returns the names of the registered backends
+func testBackends() (names []string) {
+	for _, b := range Backends() {
+		names = append(names, b.Name())
+	}
+	return names
+}
+
+// fastBackends returns the backends that run 3x3 Sobel only
+func fastBackends() (names []string) {
+	for _, name := range testBackends() {
+		if fastBackend(name) {
+			names = append(names, name)
+		}
 	}
+	return names
+}
+
+// fastBackend reports if the backend runs 3x3 Sobel only
+func fastBackend(name string) bool {
+	b, err := LookupBackend(name)
+	return err == nil && !b.Supports(Prewitt)
 }
 
 const sqrtFrom = 4356789
@@ -120,10 +177,2148 @@ func (s *SobelTS) Test_FloorSqrtFast(t *testing.T) {
 	log.Printf("sqrt( %d ) = %d ", sqrtFrom, FloorSqrtFast(sqrtFrom))
 }
 
-func (s *SobelTS) Test_FilterGraySimd(t *testing.T) {
-	for i := 0; i < 100; i++ {
-		FilterGraySimd(s.img)
-	} //
+func (s *SobelTS) Test_ApplyKernels(t *testing.T) {
+	for _, backend := range testBackends() {
+		for _, flt := range []FilterType{Sobel, SobelFast, Laplasian, Shara, Sharpen, Sobel5, Sobel7, Prewitt, Roberts, Kirsch, Robinson} {
+			for _, mag := range []Magnitude{MagnitudeL2, MagnitudeL1} {
+				res, err := Apply(s.img, WithBackend(backend), WithKernel(flt), WithMagnitude(mag))
+				if fastBackend(backend) && !simdSupports(flt) {
+					if !errors.Is(err, ErrUnsupported) {
+						t.Errorf("%s/%v: expected ErrUnsupported, got %v", backend, flt, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("%s/%v/%v: %v", backend, flt, mag, err)
+					continue
+				}
+				if res == nil || len(res.Pix) == 0 {
+					t.Errorf("%s/%v/%v: empty result", backend, flt, mag)
+				}
+			}
+		}
+	}
+}
+
+func (s *SobelTS) Test_ApplyHonorsKernel(t *testing.T) {
+	sobel, err := Apply(s.img, WithBackend(BackendMath), WithKernel(Sobel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shara, err := Apply(s.img, WithBackend(BackendMath), WithKernel(Shara))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sobel.Pix, shara.Pix) {
+		t.Errorf("Shara kernel produced Sobel output")
+	}
+	if !bytes.Equal(shara.Pix, FilterMath(s.img, Shara).Pix) {
+		t.Errorf("FilterMath ignores FilterType")
+	}
+
+	//options other than border, blur and workers run Apply
+	img := randomGray(image.Rect(0, 0, 9, 7), 26)
+	for name, filter := range sobelFilters {
+		for k, opts := range [][]Option{
+			{WithKernel(Shara)}, {WithKernels(sobelX, nil)}, {WithOrder(2)}, {WithBackend(BackendGo)},
+			{WithMagnitude(MagnitudeL1)}, {WithMapping(MappingNormalize)}, {WithScale(2)}, {WithConvolution()},
+			{WithLuma(LumaBT709), WithBorder(BorderReflect), WithWorkers(2)},
+		} {
+			want, err := Apply(img, append([]Option{WithBackend(BackendMath)}, opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := filter(img, opts...); !sameGray(got, want) {
+				t.Errorf("%s %d: differs from Apply", name, k)
+			}
+		}
+		if got, want := filter(img, WithBorder(BorderReflect), WithBorderValue(3), WithBlur(1), WithBlurRadius(2), WithWorkers(2)),
+			FilterGrayFast(img, Sobel, WithBorder(BorderReflect), WithBlur(1), WithBlurRadius(2)); !sameGray(got, want) {
+			t.Errorf("%s: border, blur and workers differ from FilterGrayFast", name)
+		}
+		//they panic where Apply returns an error
+		for k, opts := range [][]Option{
+			{WithThresholds(1, 2)}, {WithChannels(0)}, {WithBorder(Border(100))},
+			{WithBackend("nope")}, {WithBackend(BackendAsm), WithKernel(Prewitt)},
+		} {
+			func() {
+				defer func() {
+					err, _ := recover().(error)
+					if err == nil {
+						t.Errorf("%s %d: no panic", name, k)
+					} else if k < 2 && !strings.Contains(err.Error(), name+" doesn't take") {
+						t.Errorf("%s %d: panic %v", name, k, err)
+					}
+				}()
+				filter(img, opts...)
+			}()
+		}
+	}
+	if got, backend := FilterSimdBackend(img, Sobel, WithMagnitude(MagnitudeL1)); backend != BackendSimd && backend != BackendAsm {
+		t.Errorf("FilterSimdBackend with L1 ran %s", backend)
+	} else if want, _ := Apply(img, WithMagnitude(MagnitudeL1)); !sameGray(got, want) {
+		t.Errorf("FilterSimdBackend with L1 differs from Apply")
+	}
+	if _, backend := FilterSimdBackend(img, Shara, WithMagnitude(MagnitudeL1)); backend != BackendGo {
+		t.Errorf("FilterSimdBackend with Shara ran %s", backend)
+	}
+}
+
+// every function returns an error for the options it doesn't take
+func (s *SobelTS) Test_OptionsTaken(t *testing.T) {
+	img := randomGray(image.Rect(0, 0, 9, 7), 27)
+	rgba := image.NewRGBA(img.Rect)
+	g, _ := ApplyGradient(img)
+	resp, _ := Laplacian(img)
+	p := NewPlane[uint16](img.Rect)
+	thresholds, channels, luma := WithThresholds(1, 2), WithChannels(0), WithLuma(LumaBT709)
+	backend, kernel, magnitude, mapping := WithBackend(BackendGo), WithKernel(Sobel), WithMagnitude(MagnitudeL1), WithScale(2)
+	blur, border := WithBlur(1), WithBorder(BorderReplicate)
+	for _, tc := range []struct {
+		name    string
+		run     func(opts ...Option) error
+		rejects []Option
+	}{
+		{"Apply", func(opts ...Option) error {
+			_, err := Apply(img, opts...)
+			return err
+		}, []Option{thresholds, channels}},
+		{"ApplyGradient", func(opts ...Option) error {
+			_, err := ApplyGradient(img, opts...)
+			return err
+		}, []Option{thresholds, magnitude, mapping, WithConvolution()}},
+		{"ApplyPlane", func(opts ...Option) error {
+			_, err := ApplyPlane(p, opts...)
+			return err
+		}, []Option{thresholds, luma, mapping}},
+		{"ApplyGradientPlane", func(opts ...Option) error {
+			_, err := ApplyGradientPlane(p, opts...)
+			return err
+		}, []Option{channels, luma, magnitude}},
+		{"ApplyGray16", func(opts ...Option) error {
+			_, err := ApplyGray16(PlaneGray16(p), opts...)
+			return err
+		}, []Option{thresholds, luma}},
+		{"Canny", func(opts ...Option) error {
+			_, err := Canny(img, opts...)
+			return err
+		}, []Option{channels, mapping}},
+		{"Compass", func(opts ...Option) error {
+			_, _, err := Compass(img, Kirsch, opts...)
+			return err
+		}, []Option{backend, kernel, blur}},
+		{"GaussianBlur", func(opts ...Option) error {
+			_, err := GaussianBlur(img, 1, 0, opts...)
+			return err
+		}, []Option{backend, blur, luma}},
+		{"BoxBlur", func(opts ...Option) error {
+			_, err := BoxBlur(img, 1, opts...)
+			return err
+		}, []Option{kernel, mapping}},
+		{"Laplacian", func(opts ...Option) error {
+			_, err := Laplacian(img, opts...)
+			return err
+		}, []Option{backend, WithBackend("nope"), kernel, magnitude, mapping}},
+		{"LoG", func(opts ...Option) error {
+			_, err := MarrHildreth(img, 1, 1, opts...)
+			return err
+		}, []Option{backend, thresholds}},
+		{"Convolve", func(opts ...Option) error {
+			_, err := Convolve(img, sharpenX, opts...)
+			return err
+		}, []Option{kernel, backend, magnitude}},
+		{"Emboss", func(opts ...Option) error {
+			_, err := Emboss(rgba, opts...)
+			return err
+		}, []Option{luma}},
+		{"UnsharpMask", func(opts ...Option) error {
+			_, err := UnsharpMask(img, 1, 1, opts...)
+			return err
+		}, []Option{mapping, backend}},
+		{"ColorGradient", func(opts ...Option) error {
+			_, err := ColorGradient(rgba, ColorDiZenzo, opts...)
+			return err
+		}, []Option{luma, magnitude}},
+		{"FilterColor", func(opts ...Option) error {
+			_, err := FilterColor(rgba, Sobel, SpaceRGB, opts...)
+			return err
+		}, []Option{luma, thresholds}},
+		{"MagnitudeImage", func(opts ...Option) error {
+			_, err := g.MagnitudeImage(opts...)
+			return err
+		}, []Option{border, backend}},
+		{"Response.Image", func(opts ...Option) error {
+			_, err := resp.Image(opts...)
+			return err
+		}, []Option{blur, magnitude}},
+		{"NewFilterSpec", func(opts ...Option) error {
+			_, err := NewFilterSpec(opts...)
+			return err
+		}, []Option{border, backend, blur}},
+	} {
+		for k, opt := range tc.rejects {
+			if err := tc.run(opt); !errors.Is(err, ErrUnsupported) || !strings.Contains(err.Error(), tc.name) {
+				t.Errorf("%s: option %d gave %v", tc.name, k, err)
+			}
+		}
+		//and a mix of the ones it takes
+		var takes []Option
+		for _, opt := range []Option{thresholds, channels, luma, backend, kernel, magnitude, mapping, blur, border} {
+			if tc.run(opt) == nil {
+				takes = append(takes, opt)
+			}
+		}
+		if err := tc.run(takes...); err != nil || len(takes) == 0 {
+			t.Errorf("%s: %d options taken, together %v", tc.name, len(takes), err)
+		}
+	}
+	if _, err := resp.Image(WithMapping(MappingNormalize)); err == nil {
+		t.Errorf("normalized response accepted")
+	}
+}
+
+func (s *SobelTS) Test_ApplyErrors(t *testing.T) {
+	if _, err := Apply(s.img, WithBackend("nope")); err == nil {
+		t.Errorf("unknown backend accepted")
+	}
+	if _, err := Apply(s.img, WithKernel(FilterType(100))); err == nil {
+		t.Errorf("unknown kernel accepted")
+	}
+	if _, err := Apply(s.img, WithMagnitude(Magnitude(100))); err == nil {
+		t.Errorf("unknown magnitude accepted: %v", err)
+	}
+	if _, err := Apply(s.img, WithBorder(Border(100))); err == nil {
+		t.Errorf("unknown border accepted")
+	}
+}
+
+// randomGray returns an image with a low contrast noise, so no magnitude
+// exceeds 255 and backends can be compared without clipping
+func randomGray(r image.Rectangle, seed int64) *image.Gray {
+	rnd := rand.New(rand.NewSource(seed))
+	img := image.NewGray(r)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rnd.Intn(32))
+	}
+	return img
+}
+
+// referenceSobel is a straightforward sobel magnitude at (x, y)
+func referenceSobel(img *image.Gray, x, y int) float64 {
+	kx := [3][3]int{{-1, 0, 1}, {-2, 0, 2}, {-1, 0, 1}}
+	ky := [3][3]int{{-1, -2, -1}, {0, 0, 0}, {1, 2, 1}}
+	var fX, fY int
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			pixel := int(img.GrayAt(x+j-1, y+i-1).Y)
+			fX += kx[i][j] * pixel
+			fY += ky[i][j] * pixel
+		}
+	}
+	return math.Sqrt(float64(fX*fX + fY*fY))
+}
+
+func (s *SobelTS) Test_OutputGeometry(t *testing.T) {
+	img := randomGray(image.Rect(0, 0, 37, 23), 1)
+	filters := map[string]func(*image.Gray, ...Option) *image.Gray{
+		"FilterGray":     func(img *image.Gray, opts ...Option) *image.Gray { return FilterGray(img, Sobel, opts...) },
+		"FilterGrayFast": func(img *image.Gray, opts ...Option) *image.Gray { return FilterGrayFast(img, Sobel, opts...) },
+	}
+	for name, filter := range sobelFilters {
+		filters[name] = filter
+	}
+	b := img.Bounds()
+	for name, filter := range filters {
+		res := filter(img)
+		if res.Bounds() != b {
+			t.Errorf("%s: bounds %v, expected %v", name, res.Bounds(), b)
+			continue
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				got := float64(res.GrayAt(x, y).Y)
+				if x == b.Min.X || y == b.Min.Y || x == b.Max.X-1 || y == b.Max.Y-1 {
+					if got != 0 {
+						t.Errorf("%s: border pixel (%d, %d) = %v", name, x, y, got)
+					}
+					continue
+				}
+				if want := referenceSobel(img, x, y); math.Abs(got-want) > 1 {
+					t.Errorf("%s: pixel (%d, %d) = %v, expected %.2f", name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func (s *SobelTS) Test_BorderIndex(t *testing.T) {
+	expected := map[Border][]int{
+		//                i = -3, -2, -1, 0, 1, 2, 3, 4, 5, 6, 7
+		BorderReplicate:  {0, 0, 0, 0, 1, 2, 3, 4, 4, 4, 4},
+		BorderReflect:    {2, 1, 0, 0, 1, 2, 3, 4, 4, 3, 2},
+		BorderReflect101: {3, 2, 1, 0, 1, 2, 3, 4, 3, 2, 1},
+		BorderWrap:       {2, 3, 4, 0, 1, 2, 3, 4, 0, 1, 2},
+	}
+	for b, want := range expected {
+		for i := -3; i <= 7; i++ {
+			if got := borderIndex(i, 5, b); got != want[i+3] {
+				t.Errorf("%v: borderIndex(%d, 5) = %d, expected %d", b, i, got, want[i+3])
+			}
+		}
+	}
+	//reflections larger than the image itself
+	if got := borderIndex(-4, 2, BorderReflect101); got != 0 {
+		t.Errorf("Reflect101: borderIndex(-4, 2) = %d, expected 0", got)
+	}
+	if got := borderIndex(-2, 1, BorderReflect101); got != 0 {
+		t.Errorf("Reflect101: borderIndex(-2, 1) = %d, expected 0", got)
+	}
+}
+
+func (s *SobelTS) Test_Borders(t *testing.T) {
+	img := randomGray(image.Rect(0, 0, 19, 11), 2)
+	b := img.Bounds()
+	backends := testBackends()
+	for _, border := range []Border{BorderReplicate, BorderConstant, BorderReflect, BorderReflect101, BorderWrap} {
+		padded := padGray(img, 1, border, 7)
+		for _, backend := range backends {
+			res, err := Apply(img, WithBackend(backend), WithBorder(border), WithBorderValue(7))
+			if err != nil {
+				t.Errorf("%s/%v: %v", backend, border, err)
+				continue
+			}
+			if res.Bounds() != b {
+				t.Errorf("%s/%v: bounds %v, expected %v", backend, border, res.Bounds(), b)
+				continue
+			}
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					got := float64(res.GrayAt(x, y).Y)
+					if want := referenceSobel(padded, x, y); math.Abs(got-want) > 1 {
+						t.Errorf("%s/%v: pixel (%d, %d) = %v, expected %.2f", backend, border, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+	for _, backend := range backends {
+		res, err := Apply(img, WithBackend(backend), WithBorder(BorderCrop))
+		if err != nil {
+			t.Errorf("%s/Crop: %v", backend, err)
+			continue
+		}
+		if res.Bounds() != b.Inset(1) {
+			t.Errorf("%s/Crop: bounds %v, expected %v", backend, res.Bounds(), b.Inset(1))
+		}
+		full, _ := Apply(img, WithBackend(backend))
+		if c := full.SubImage(b.Inset(1)).(*image.Gray); !sameGray(c, res) {
+			t.Errorf("%s/Crop: differs from uncropped output", backend)
+		}
+	}
+
+	//images without pixels have no border to map into
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 0, 7), image.Rect(2, 3, 7, 3)} {
+		empty := image.NewGray(r)
+		for border := BorderNone; border <= BorderWrap; border++ {
+			for _, backend := range backends {
+				res, err := Apply(empty, WithBackend(backend), WithBorder(border))
+				if err != nil || !res.Bounds().Empty() {
+					t.Errorf("%s/%v %v: %v, %v", backend, border, r, res.Bounds(), err)
+				}
+			}
+			if res, err := GaussianBlur(empty, 1, 0, WithBorder(border)); err != nil || !res.Bounds().Empty() {
+				t.Errorf("GaussianBlur/%v %v: %v", border, r, err)
+			}
+			if res, err := BoxBlur(empty, 2, WithBorder(border)); err != nil || !res.Bounds().Empty() {
+				t.Errorf("BoxBlur/%v %v: %v", border, r, err)
+			}
+			if res, _, err := Compass(empty, Kirsch, WithBorder(border)); err != nil || !res.Bounds().Empty() {
+				t.Errorf("Compass/%v %v: %v", border, r, err)
+			}
+			if res, err := Canny(empty, WithBorder(border)); err != nil || !res.Bounds().Empty() {
+				t.Errorf("Canny/%v %v: %v", border, r, err)
+			}
+			if _, err := FilterColor(image.NewRGBA(r), Sobel, SpaceRGB, WithBorder(border)); err != nil {
+				t.Errorf("FilterColor/%v %v: %v", border, r, err)
+			}
+			if _, err := ColorGradient(image.NewRGBA(r), ColorDiZenzo, WithBorder(border)); err != nil {
+				t.Errorf("ColorGradient/%v %v: %v", border, r, err)
+			}
+		}
+	}
+}
+
+// packedCopy copies img into a new image with the same bounds
+// and Stride equal to its width
+func packedCopy(img *image.Gray) *image.Gray {
+	res := image.NewGray(img.Bounds())
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		copy(res.Pix[res.PixOffset(res.Rect.Min.X, y):], img.Pix[img.PixOffset(img.Rect.Min.X, y):img.PixOffset(img.Rect.Max.X, y)])
+	}
+	return res
+}
+
+func (s *SobelTS) Test_SubImages(t *testing.T) {
+	big := randomGray(image.Rect(-5, -3, 40, 30), 3)
+	sub := big.SubImage(image.Rect(3, 2, 24, 17)).(*image.Gray)
+	packed := packedCopy(sub)
+	filters := map[string]func(*image.Gray, ...Option) *image.Gray{
+		"FilterGray":     func(img *image.Gray, opts ...Option) *image.Gray { return FilterGray(img, Shara, opts...) },
+		"FilterGrayFast": func(img *image.Gray, opts ...Option) *image.Gray { return FilterGrayFast(img, Sobel, opts...) },
+	}
+	for name, filter := range sobelFilters {
+		filters[name] = filter
+	}
+	for name, filter := range filters {
+		for _, border := range []Border{BorderNone, BorderCrop, BorderReflect} {
+			got := filter(sub, WithBorder(border))
+			want := filter(packed, WithBorder(border))
+			if !sameGray(got, want) {
+				t.Errorf("%s/%v: sub-image result differs from packed copy", name, border)
+			}
+		}
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for i := range rgba.Pix {
+		rgba.Pix[i] = uint8(i)
+	}
+	subRGBA := rgba.SubImage(image.Rect(4, 5, 15, 12))
+	if g := ToGrayscale(subRGBA); g.Bounds() != subRGBA.Bounds() {
+		t.Errorf("ToGrayscale: bounds %v, expected %v", g.Bounds(), subRGBA.Bounds())
+	} else if g.GrayAt(4, 5) != color.GrayModel.Convert(rgba.At(4, 5)) {
+		t.Errorf("ToGrayscale: pixel (4, 5) is not converted")
+	}
+}
+
+func (s *SobelTS) Test_Workers(t *testing.T) {
+	img := randomGray(image.Rect(1, 2, 131, 203), 4)
+	for _, backend := range testBackends() {
+		for _, border := range []Border{BorderNone, BorderCrop, BorderReflect101} {
+			want, err := Apply(img, WithBackend(backend), WithBorder(border))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, workers := range []int{0, 2, 5, 64} {
+				got, err := Apply(img, WithBackend(backend), WithBorder(border), WithWorkers(workers))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !sameGray(got, want) {
+					t.Errorf("%s/%v: %d workers differ from sequential", backend, border, workers)
+				}
+			}
+		}
+	}
+	if _, err := Apply(img, WithWorkers(-1)); err == nil {
+		t.Errorf("negative workers accepted")
+	}
+
+	//every function validates the workers and the executor
+	bad := []Option{WithExecutor(nil), WithWorkers(0)}
+	rgba := image.NewRGBA(img.Rect)
+	for name, run := range map[string]func() error{
+		"Apply": func() error {
+			_, err := Apply(img, bad...)
+			return err
+		},
+		"Compass": func() error {
+			_, _, err := Compass(img, Kirsch, bad...)
+			return err
+		},
+		"GaussianBlur": func() error {
+			_, err := GaussianBlur(img, 1, 0, bad...)
+			return err
+		},
+		"BoxBlur": func() error {
+			_, err := BoxBlur(img, 2, bad...)
+			return err
+		},
+		"Laplacian": func() error {
+			_, err := Laplacian(img, bad...)
+			return err
+		},
+		"Convolve": func() error {
+			_, err := Convolve(rgba, sharpenX, bad...)
+			return err
+		},
+		"UnsharpMask": func() error {
+			_, err := UnsharpMask(img, 1, 1, bad...)
+			return err
+		},
+		"Canny": func() error {
+			_, err := Canny(img, bad...)
+			return err
+		},
+		"ColorGradient": func() error {
+			_, err := ColorGradient(rgba, ColorMaxChannel, bad...)
+			return err
+		},
+		"FilterColor": func() error {
+			_, err := FilterColor(rgba, Sobel, SpaceLab, bad...)
+			return err
+		},
+		"ApplyGray16": func() error {
+			_, err := ApplyGray16(image.NewGray16(img.Rect), bad...)
+			return err
+		},
+		"FilterGrayFast": func() (err error) {
+			defer func() { err, _ = recover().(error) }()
+			FilterGrayFast(img, Sobel, bad...)
+			return nil
+		},
+	} {
+		if err := run(); err == nil || !strings.Contains(err.Error(), "executor") {
+			t.Errorf("%s: nil executor gave %v", name, err)
+		}
+	}
+}
+
+func (s *SobelTS) Test_ExecutorLimit(t *testing.T) {
+	e := NewExecutor(2)
+	var active, peak int32
+	var tasks []func()
+	for i := 0; i < 20; i++ {
+		tasks = append(tasks, func() {
+			n := atomic.AddInt32(&active, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&active, -1)
+		})
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ { //concurrent callers share the limit
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.run(tasks)
+		}()
+	}
+	wg.Wait()
+	if peak > 2 {
+		t.Errorf("%d tasks run at once, executor limit is 2", peak)
+	}
+}
+
+func (s *SobelTS) Test_CustomKernels(t *testing.T) {
+	img := randomGray(image.Rect(0, 0, 29, 17), 5)
+	kx, err := NewKernel(3, []int{-1, 0, 1, -2, 0, 2, -1, 0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ky, err := NewKernel(3, []int{-1, -2, -1, 0, 0, 0, 1, 2, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom, err := Apply(img, WithKernels(kx, ky), WithBorder(BorderReflect))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if builtin, _ := Apply(img, WithBorder(BorderReflect)); !sameGray(custom, builtin) {
+		t.Errorf("custom Sobel kernels differ from the built-in ones")
+	}
+
+	//5x5 mean as a single float kernel, the frame of radius 2 stays black
+	weights := make([]float64, 25)
+	for i := range weights {
+		weights[i] = 1
+	}
+	box, err := NewKernelFloat(5, weights)
+	if err != nil {
+		t.Fatal(err)
+	}
+	box.Divisor = 25
+	res, err := Apply(img, WithKernels(box, nil), WithMagnitude(MagnitudeL1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var want float64
+			if x >= b.Min.X+2 && y >= b.Min.Y+2 && x < b.Max.X-2 && y < b.Max.Y-2 {
+				for i := -2; i <= 2; i++ {
+					for j := -2; j <= 2; j++ {
+						want += float64(img.GrayAt(x+j, y+i).Y)
+					}
+				}
+				want = math.Round(want / 25)
+			}
+			if got := res.GrayAt(x, y).Y; float64(got) != want {
+				t.Errorf("5x5 mean: pixel (%d, %d) = %d, expected %v", x, y, got, want)
+			}
+		}
+	}
+
+	if _, err := NewKernel(4, make([]int, 16)); err == nil {
+		t.Errorf("even kernel size accepted")
+	}
+	if _, err := NewKernel(3, make([]int, 8)); err == nil {
+		t.Errorf("wrong number of weights accepted")
+	}
+	if _, err := Apply(img, WithKernels(&Kernel{Size: 3}, nil)); err == nil {
+		t.Errorf("kernel without weights accepted")
+	}
+	nan := []float64{0, 1, 0, 1, math.NaN(), 1, 0, 1, 0}
+	if _, err := NewKernelFloat(3, nan); err == nil {
+		t.Errorf("NaN weight accepted")
+	}
+	if _, err := NewKernelFloat(3, []float64{0, 0, 0, 0, math.Inf(-1), 0, 0, 0, 0}); err == nil {
+		t.Errorf("infinite weight accepted")
+	}
+	for _, bad := range []Kernel{{Offset: math.NaN()}, {Offset: math.Inf(1)}, {Divisor: math.NaN()}, {Divisor: math.Inf(-1)}} {
+		bad.Size, bad.Weights = 3, kx.Weights
+		if _, err := Apply(img, WithKernels(&bad, nil)); err == nil {
+			t.Errorf("divisor %v, offset %v accepted", bad.Divisor, bad.Offset)
+		}
+	}
+	//a negative divisor negates the response
+	neg := *kx
+	neg.Divisor = -1
+	if got, err := Apply(img, WithKernels(&neg, nil)); err != nil {
+		t.Error(err)
+	} else if want, _ := Apply(img, WithKernels(kx, nil)); !sameGray(got, want) {
+		t.Errorf("negative divisor changes the magnitude")
+	}
+	if _, err := Apply(img, WithKernels(kx, ky), WithBackend(BackendAsm)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("asm backend accepted user kernels: %v", err)
+	}
+}
+
+func (s *SobelTS) Test_SeparableKernels(t *testing.T) {
+	kx, _ := NewKernel(3, []int{-1, 0, 1, -2, 0, 2, -1, 0, 1})
+	row, col, ok := kx.Separable()
+	if !ok {
+		t.Fatalf("Sobel X is not detected as separable")
+	}
+	if !reflect.DeepEqual(col, []float64{1, 2, 1}) || !reflect.DeepEqual(row, []float64{-1, 0, 1}) {
+		t.Errorf("Sobel X factors %v ⊗ %v, expected [1 2 1] ⊗ [-1 0 1]", col, row)
+	}
+	if _, _, ok := laplasianX.Separable(); ok {
+		t.Errorf("Laplacian is detected as separable")
+	}
+
+	img := randomGray(image.Rect(2, 1, 61, 47), 6)
+	rnd := rand.New(rand.NewSource(7))
+	for _, size := range []int{3, 5, 7} {
+		row := make([]float64, size)
+		col := make([]float64, size)
+		for i := range row {
+			row[i] = float64(rnd.Intn(7) - 3)
+			col[i] = float64(rnd.Intn(5) - 1)
+		}
+		sx, err := NewSeparableKernel(row, col)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sy, _ := NewSeparableKernel(col, row)
+		sx.Divisor, sy.Divisor = 4, 4
+		//the same weights without the factorization go through one pass
+		dx, _ := NewKernelFloat(size, sx.Weights)
+		dy, _ := NewKernelFloat(size, sy.Weights)
+		dx.Divisor, dy.Divisor = 4, 4
+
+		got := filterGrayKernels(img, sx, sy, magnitudeMath)
+		cx, cy := dx.prepare(img.Stride), dy.prepare(img.Stride)
+		r := size / 2
+		b := img.Bounds()
+		for y := b.Min.Y + r; y < b.Max.Y-r; y++ {
+			for x := b.Min.X + r; x < b.Max.X-r; x++ {
+				i := img.PixOffset(x, y)
+				want := magnitudeMath(absRound(cx.response(img.Pix, i)), absRound(cy.response(img.Pix, i)))
+				if got.GrayAt(x, y).Y != want {
+					t.Errorf("%dx%d: pixel (%d, %d) = %d, expected %d", size, size, x, y, got.GrayAt(x, y).Y, want)
+				}
+			}
+		}
+	}
+}
+
+func (s *SobelTS) Test_SobelKernels(t *testing.T) {
+	expected := []struct {
+		size, order int
+		weights     []float64
+	}{
+		{3, 0, []float64{1, 2, 1}},
+		{3, 1, []float64{-1, 0, 1}},
+		{3, 2, []float64{1, -2, 1}},
+		{5, 0, []float64{1, 4, 6, 4, 1}},
+		{5, 1, []float64{-1, -2, 0, 2, 1}},
+		{5, 2, []float64{1, 0, -2, 0, 1}},
+		{7, 1, []float64{-1, -4, -5, 0, 5, 4, 1}},
+		{7, 2, []float64{1, 2, -1, -4, -1, 2, 1}},
+	}
+	for _, e := range expected {
+		if got := derivativeWeights(e.size, e.order); !reflect.DeepEqual(got, e.weights) {
+			t.Errorf("size %d order %d: %v, expected %v", e.size, e.order, got, e.weights)
+		}
+	}
+	k, err := SobelKernel(3, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{1, 0, -1, 0, 0, 0, -1, 0, 1}; !reflect.DeepEqual(k.Weights, want) {
+		t.Errorf("SobelKernel(3, 1, 1) = %v, expected %v", k.Weights, want)
+	}
+	for _, bad := range [][3]int{{4, 1, 0}, {9, 1, 0}, {3, 0, 0}, {3, 3, 0}, {5, -1, 1}} {
+		if _, err := SobelKernel(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("SobelKernel%v accepted", bad)
+		}
+	}
+
+	img := randomGray(image.Rect(0, 0, 41, 33), 8)
+	for _, flt := range []FilterType{Sobel, Sobel5, Sobel7} {
+		for _, order := range []int{1, 2} {
+			got, err := Apply(img, WithKernel(flt), WithOrder(order), WithBorder(BorderReflect101))
+			if err != nil {
+				t.Fatal(err)
+			}
+			kx, _ := SobelKernel(flt.aperture(), order, 0)
+			ky, _ := SobelKernel(flt.aperture(), 0, order)
+			want, _ := Apply(img, WithKernels(kx, ky), WithBorder(BorderReflect101))
+			if !sameGray(got, want) {
+				t.Errorf("%v order %d differs from SobelKernel", flt, order)
+			}
+		}
+	}
+	if _, err := Apply(img, WithOrder(3)); err == nil {
+		t.Errorf("order 3 accepted")
+	}
+	if _, err := Apply(img, WithKernel(Laplasian), WithOrder(2)); err == nil {
+		t.Errorf("order 2 accepted for Laplasian")
+	}
+	if _, err := Apply(img, WithKernel(Sobel5), WithBackend(BackendAsm)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("asm backend accepted Sobel5: %v", err)
+	}
+}
+
+func (s *SobelTS) Test_GradientOperators(t *testing.T) {
+	for _, flt := range []FilterType{Sobel, Scharr, Prewitt, Sobel5, Sobel7} {
+		kx, ky := flt.kernels()
+		n := kx.Size
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if kx.Weights[i*n+j] != ky.Weights[j*n+i] {
+					t.Errorf("%v: Y kernel is not X kernel transposed", flt)
+				}
+			}
+		}
+	}
+
+	//Roberts cross on a single bright pixel: the responses are at the pixel
+	//itself and its left, upper and upper left neighbours
+	img := image.NewGray(image.Rect(0, 0, 6, 6))
+	img.SetGray(3, 3, color.Gray{Y: 100})
+	res, err := Apply(img, WithKernel(Roberts), WithMagnitude(MagnitudeL1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 6; x++ {
+			want := uint8(0)
+			if (x == 2 || x == 3) && (y == 2 || y == 3) {
+				want = 100
+			}
+			if got := res.GrayAt(x, y).Y; got != want {
+				t.Errorf("Roberts: pixel (%d, %d) = %d, expected %d", x, y, got, want)
+			}
+		}
+	}
+}
+
+func (s *SobelTS) Test_Compass(t *testing.T) {
+	east := []float64{-3, -3, 5, -3, 0, 5, -3, -3, 5}
+	if !reflect.DeepEqual(kirschMasks[2].Weights, east) {
+		t.Errorf("Kirsch east mask %v, expected %v", kirschMasks[2].Weights, east)
+	}
+
+	//bright half planes on each side of the image
+	sides := map[int]func(x, y int) bool{
+		0: func(x, y int) bool { return y < 5 },  //north
+		2: func(x, y int) bool { return x >= 5 }, //east
+		4: func(x, y int) bool { return y >= 5 }, //south
+		6: func(x, y int) bool { return x < 5 },  //west
+	}
+	for _, flt := range []FilterType{Kirsch, Robinson} {
+		for dir, bright := range sides {
+			img := image.NewGray(image.Rect(0, 0, 10, 10))
+			for y := 0; y < 10; y++ {
+				for x := 0; x < 10; x++ {
+					if bright(x, y) {
+						img.SetGray(x, y, color.Gray{Y: 10})
+					}
+				}
+			}
+			mag, direction, err := Compass(img, flt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//a pixel right at the edge, on its dark side
+			x, y := 5, 5
+			switch dir {
+			case 2:
+				x = 4
+			case 0:
+				y = 5
+			case 4:
+				y = 4
+			case 6:
+				x = 5
+			}
+			if mag.GrayAt(x, y).Y == 0 || int(direction.GrayAt(x, y).Y) != dir {
+				t.Errorf("%v: (%d, %d) magnitude %d direction %d, expected direction %d",
+					flt, x, y, mag.GrayAt(x, y).Y, direction.GrayAt(x, y).Y, dir)
+			}
+			if res, _ := Apply(img, WithKernel(flt)); !sameGray(res, mag) {
+				t.Errorf("%v: Apply differs from Compass", flt)
+			}
+		}
+	}
+	img := randomGray(image.Rect(0, 0, 70, 90), 9)
+	mag, direction, _ := Compass(img, Kirsch, WithBorder(BorderCrop))
+	magW, directionW, _ := Compass(img, Kirsch, WithBorder(BorderCrop), WithWorkers(3))
+	if !sameGray(mag, magW) || !sameGray(direction, directionW) {
+		t.Errorf("Kirsch: 3 workers differ from sequential")
+	}
+	if _, _, err := Compass(img, Sobel); err == nil {
+		t.Errorf("Compass accepted Sobel")
+	}
+	if _, err := Apply(s.img, WithKernel(Kirsch), WithBackend(BackendAsm)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("asm backend accepted Kirsch: %v", err)
+	}
+}
+
+// sameGray compares pixels of two images with equal bounds
+func (s *SobelTS) Test_Gradient(t *testing.T) {
+	//brighter to the right and to the bottom: Dx = 4*2*2, Dy = 4*2*4
+	img := image.NewGray(image.Rect(3, 4, 13, 14))
+	for y := 4; y < 14; y++ {
+		for x := 3; x < 13; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(2*x + 4*y)})
+		}
+	}
+	for _, backend := range testBackends() {
+		g, err := ApplyGradient(img, WithBackend(backend))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.Bounds() != img.Bounds() {
+			t.Fatalf("%s: bounds %v, expected %v", backend, g.Bounds(), img.Bounds())
+		}
+		if dx, dy := g.At(7, 8); dx != 16 || dy != 32 {
+			t.Errorf("%s: gradient (%v, %v), expected (16, 32)", backend, dx, dy)
+		}
+		if dx, dy := g.At(3, 8); dx != 0 || dy != 0 {
+			t.Errorf("%s: gradient at the edge (%v, %v), expected 0", backend, dx, dy)
+		}
+		if o := g.Orientation(7, 8); math.Abs(o-math.Atan2(32, 16)) > 1e-9 {
+			t.Errorf("%s: orientation %v", backend, o)
+		}
+	}
+
+	//signs follow the direction of the edge
+	flip := image.NewGray(img.Bounds())
+	for y := 4; y < 14; y++ {
+		for x := 3; x < 13; x++ {
+			flip.SetGray(x, y, img.GrayAt(15-x, 17-y))
+		}
+	}
+	g, _ := ApplyGradient(flip)
+	if dx, dy := g.At(7, 8); dx != -16 || dy != -32 {
+		t.Errorf("flipped gradient (%v, %v), expected (-16, -32)", dx, dy)
+	}
+
+	rnd := randomGray(image.Rect(1, 2, 71, 93), 10)
+	g, _ = ApplyGradient(rnd)
+	for y := 3; y < 92; y++ {
+		for x := 2; x < 70; x++ {
+			if m, want := g.Magnitude(x, y), referenceSobel(rnd, x, y); math.Abs(m-want) > 1e-3 {
+				t.Fatalf("(%d, %d): magnitude %v, expected %v", x, y, m, want)
+			}
+		}
+	}
+	for _, border := range []Border{BorderNone, BorderCrop, BorderReplicate} {
+		want, _ := Apply(rnd, WithBorder(border))
+		g, err := ApplyGradient(rnd, WithBorder(border))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.Bounds() != want.Bounds() {
+			t.Errorf("%v: bounds %v, Apply %v", border, g.Bounds(), want.Bounds())
+		}
+		gw, _ := ApplyGradient(rnd, WithBorder(border), WithWorkers(4))
+		if !reflect.DeepEqual(g, gw) {
+			t.Errorf("%v: 4 workers differ from sequential", border)
+		}
+		for _, backend := range fastBackends() {
+			gs, _ := ApplyGradient(rnd, WithBorder(border), WithBackend(backend))
+			if !reflect.DeepEqual(g, gs) {
+				t.Errorf("%v: %s gradient differs from go", border, backend)
+			}
+		}
+	}
+
+	//the magnitude of Apply is truncated, L1 is exact
+	g5, _ := ApplyGradient(rnd, WithKernel(Sobel5), WithBorder(BorderReflect))
+	want, _ := Apply(rnd, WithKernel(Sobel5), WithMagnitude(MagnitudeL1), WithBorder(BorderReflect))
+	for y := 2; y < 93; y++ {
+		for x := 1; x < 71; x++ {
+			dx, dy := g5.At(x, y)
+			if l1 := math.Min(math.Abs(float64(dx))+math.Abs(float64(dy)), 255); uint8(l1) != want.GrayAt(x, y).Y {
+				t.Fatalf("Sobel5: (%d, %d) L1 %v, Apply %d", x, y, l1, want.GrayAt(x, y).Y)
+			}
+		}
+	}
+	if _, err := ApplyGradient(rnd, WithKernel(Kirsch)); err == nil {
+		t.Errorf("ApplyGradient accepted Kirsch")
+	}
+	if _, err := ApplyGradient(rnd, WithKernel(Sobel7), WithBackend(BackendAsm)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("asm gradient accepted Sobel7: %v", err)
+	}
+}
+
+func (s *SobelTS) Test_Magnitudes(t *testing.T) {
+	//a full range step: the magnitude is 1020, it used to wrap around
+	step := image.NewGray(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 4; x < 8; x++ {
+			step.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	legacy := map[string]*image.Gray{
+		"FilterGray":     FilterGray(step, Sobel),
+		"FilterGrayFast": FilterGrayFast(step, Sobel),
+	}
+	for name, filter := range sobelFilters {
+		legacy[name] = filter(step)
+	}
+	for name, res := range legacy {
+		if v := res.GrayAt(4, 4).Y; v != 255 {
+			t.Errorf("%s: step edge %d, expected 255", name, v)
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(11))
+	img := image.NewGray(image.Rect(0, 0, 61, 47))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rnd.Intn(256) / 4)
+	}
+	norms := map[Magnitude]func(x, y float64) float64{
+		MagnitudeL2:      func(x, y float64) float64 { return math.Sqrt(x*x + y*y) },
+		MagnitudeL1:      func(x, y float64) float64 { return math.Abs(x) + math.Abs(y) },
+		MagnitudeLInf:    func(x, y float64) float64 { return math.Max(math.Abs(x), math.Abs(y)) },
+		MagnitudeSquared: func(x, y float64) float64 { return x*x + y*y },
+	}
+	g, _ := ApplyGradient(img)
+	mappings := map[string][]Option{
+		"Saturate":  nil,
+		"Scale":     {WithScale(0.25)},
+		"Normalize": {WithMapping(MappingNormalize)},
+		"Range":     {WithRange(20, 530)},
+	}
+	for m, norm := range norms {
+		var max float64
+		for y := 1; y < 46; y++ {
+			for x := 1; x < 60; x++ {
+				dx, dy := g.At(x, y)
+				max = math.Max(max, norm(float64(dx), float64(dy)))
+			}
+		}
+		for name, mapping := range mappings {
+			want := image.NewGray(img.Bounds())
+			for y := 1; y < 46; y++ {
+				for x := 1; x < 60; x++ {
+					dx, dy := g.At(x, y)
+					v := norm(float64(dx), float64(dy))
+					switch name {
+					case "Scale":
+						v *= 0.25
+					case "Normalize":
+						v *= 255 / max
+					case "Range":
+						v = (v - 20) / 2
+					}
+					want.SetGray(x, y, color.Gray{Y: uint8(math.Max(0, math.Min(255, math.Floor(v+0.5))))})
+				}
+			}
+			for _, backend := range testBackends() {
+				got, err := Apply(img, append([]Option{WithBackend(backend), WithMagnitude(m)}, mapping...)...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !sameGray(got, want) {
+					t.Errorf("%s: %v %s differs from the reference", backend, m, name)
+				}
+			}
+			if got, err := g.MagnitudeImage(append([]Option{WithMagnitude(m)}, mapping...)...); err != nil || !sameGray(got, want) {
+				t.Errorf("gradient: %v %s differs from the reference", m, name)
+			}
+		}
+	}
+	for k, opt := range []Option{WithMagnitude(Magnitude(9)), WithMapping(Mapping(9)), WithScale(-1), WithRange(5, 5)} {
+		if _, err := g.MagnitudeImage(opt); err == nil {
+			t.Errorf("%d: invalid magnitude or mapping accepted", k)
+		}
+	}
+
+	bad := [][]Option{
+		{WithScale(0)},
+		{WithScale(math.Inf(1))},
+		{WithRange(5, 5)},
+		{WithMapping(Mapping(100))},
+		{WithKernel(Kirsch), WithMapping(MappingNormalize)},
+	}
+	for _, opts := range bad {
+		if _, err := Apply(img, opts...); err == nil {
+			t.Errorf("invalid mapping accepted: %v", newOptions(opts).mapping)
+		}
+	}
+}
+
+func (s *SobelTS) Test_Canny(t *testing.T) {
+	//a bright square 20..39 with a little noise
+	rnd := rand.New(rand.NewSource(12))
+	img := image.NewGray(image.Rect(0, 0, 60, 60))
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 60; x++ {
+			v := 40 + rnd.Intn(8)
+			if x >= 20 && x < 40 && y >= 20 && y < 40 {
+				v += 120
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+	for k, opts := range [][]Option{
+		{WithAutoThresholds(0.97, 0.4)},
+		{WithBlur(1.4), WithAutoThresholds(0.97, 0.4)},
+		{WithThresholds(100, 300)},
+		{WithBlur(1), WithBackend(BackendAsm), WithThresholds(150, 250)},
+	} {
+		edges, err := Canny(img, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < 60; y++ {
+			for x := 0; x < 60; x++ {
+				v := edges.GrayAt(x, y).Y
+				if v != 0 && v != 255 {
+					t.Fatalf("%d: pixel (%d, %d) = %d is not binary", k, x, y, v)
+				}
+				near := (x >= 18 && x <= 41 && y >= 18 && y <= 41) &&
+					!(x >= 22 && x <= 37 && y >= 22 && y <= 37)
+				if v != 0 && !near {
+					t.Errorf("%d: edge at (%d, %d) away from the square", k, x, y)
+				}
+			}
+		}
+		//one pixel wide edges crossing every middle row and column
+		for i := 25; i < 35; i++ {
+			var row, col int
+			for j := 0; j < 60; j++ {
+				row += int(edges.GrayAt(j, i).Y) / 255
+				col += int(edges.GrayAt(i, j).Y) / 255
+			}
+			if row != 2 || col != 2 {
+				t.Errorf("%d: row %d has %d edges, column %d has %d, expected 2", k, i, row, i, col)
+			}
+		}
+		opts = append(opts, WithWorkers(3))
+		if edgesW, _ := Canny(img, opts...); !sameGray(edges, edgesW) {
+			t.Errorf("%d: 3 workers differ from sequential", k)
+		}
+	}
+
+	//hysteresis follows weak edges connected to strong ones only
+	thin := []float64{
+		0, 50, 50, 200, 0, 50,
+	}
+	edges := hysteresis(image.Rect(0, 0, 6, 1), thin, 40, 100)
+	if want := []uint8{0, 255, 255, 255, 0, 0}; !reflect.DeepEqual(edges.Pix, want) {
+		t.Errorf("hysteresis %v, expected %v", edges.Pix, want)
+	}
+	//zero thresholds don't make suppressed pixels edges
+	edges = hysteresis(image.Rect(0, 0, 4, 1), []float64{0, 0, 30, 0}, 0, 0)
+	if want := []uint8{0, 0, 255, 0}; !reflect.DeepEqual(edges.Pix, want) {
+		t.Errorf("hysteresis with zero thresholds %v, expected %v", edges.Pix, want)
+	}
+	noise := randomGray(image.Rect(0, 0, 40, 30), 31)
+	zero, err := Canny(noise, WithThresholds(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//magnitudes of integer sums are 0 or at least 1
+	if half, _ := Canny(noise, WithThresholds(0.5, 0.5)); !sameGray(zero, half) {
+		t.Errorf("zero thresholds mark suppressed pixels as edges")
+	}
+
+	if edges, _ := Canny(image.NewGray(image.Rect(0, 0, 10, 10))); !sameGray(edges, image.NewGray(edges.Bounds())) {
+		t.Errorf("edges in a flat image")
+	}
+	//default automatic thresholds without noise
+	square := image.NewGray(image.Rect(0, 0, 60, 60))
+	for y := 20; y < 40; y++ {
+		for x := 20; x < 40; x++ {
+			square.SetGray(x, y, color.Gray{Y: 100})
+		}
+	}
+	edges, _ = Canny(square)
+	var n int
+	for _, v := range edges.Pix {
+		n += int(v) / 255
+	}
+	if n < 4*19 || n > 4*21 {
+		t.Errorf("square contour has %d edge pixels", n)
+	}
+	for k, opts := range [][]Option{
+		{WithBlur(-1)},
+		{WithThresholds(5, 2)},
+		{WithAutoThresholds(1, 0.5)},
+		{WithKernel(Kirsch)},
+	} {
+		if _, err := Canny(img, opts...); err == nil {
+			t.Errorf("%d: invalid options accepted", k)
+		}
+	}
+}
+
+func (s *SobelTS) Test_Threshold(t *testing.T) {
+	//two classes: 70% of pixels around 20, 30% around 200
+	rnd := rand.New(rand.NewSource(13))
+	img := image.NewGray(image.Rect(5, 5, 105, 105))
+	for i := range img.Pix {
+		v := 10 + rnd.Intn(21)
+		if i%10 >= 7 {
+			v = 180 + rnd.Intn(41)
+		}
+		img.Pix[i] = uint8(v)
+	}
+	tests := []struct {
+		method   ThresholdMethod
+		param    float64
+		min, max uint8
+	}{
+		{ThresholdFixed, 100, 100, 100},
+		{ThresholdOtsu, 0, 30, 179},
+		{ThresholdPercentile, 70, 30, 30},
+		{ThresholdPercentile, 100, 220, 220},
+		{ThresholdTriangle, 0, 30, 179},
+	}
+	for _, tt := range tests {
+		binary, threshold, err := Threshold(img, tt.method, tt.param)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if threshold < tt.min || threshold > tt.max {
+			t.Errorf("%v(%v): threshold %d, expected %d..%d", tt.method, tt.param, threshold, tt.min, tt.max)
+		}
+		if binary.Bounds() != img.Bounds() {
+			t.Errorf("%v: bounds %v", tt.method, binary.Bounds())
+		}
+		for i, v := range img.Pix {
+			if (v > threshold) != (binary.Pix[i] == 255) {
+				t.Fatalf("%v: pixel %d is %d, binary %d", tt.method, v, threshold, binary.Pix[i])
+			}
+		}
+	}
+
+	//a magnitude like histogram: a peak at 0 and a long tail
+	var hist [256]int
+	hist[0], hist[1], hist[2] = 1000, 500, 100
+	for v := 3; v < 100; v++ {
+		hist[v] = 10
+	}
+	if th := triangleThreshold(hist); th < 2 || th > 10 {
+		t.Errorf("triangle threshold of a decaying histogram %d", th)
+	}
+	if th := otsuThreshold([256]int{5: 10}); th != 0 {
+		t.Errorf("Otsu threshold of a flat image %d", th)
+	}
+
+	if _, _, err := Threshold(img, ThresholdFixed, 256); err == nil {
+		t.Errorf("threshold 256 accepted")
+	}
+	if _, _, err := Threshold(img, ThresholdPercentile, -1); err == nil {
+		t.Errorf("percentile -1 accepted")
+	}
+	if _, _, err := Threshold(img, ThresholdMethod(100), 0); err == nil {
+		t.Errorf("unknown method accepted")
+	}
+}
+
+func (s *SobelTS) Test_Blur(t *testing.T) {
+	//pre-smoothing reflects the image, empty ones have nothing to reflect
+	for _, blur := range []Option{WithBlur(1), WithBoxBlur(2)} {
+		empty := image.NewGray(image.Rect(0, 0, 5, 0))
+		if res, err := Apply(empty, blur); err != nil || !res.Bounds().Empty() {
+			t.Errorf("Apply of an empty image: %v", err)
+		}
+		if res, err := Canny(empty, blur); err != nil || !res.Bounds().Empty() {
+			t.Errorf("Canny of an empty image: %v", err)
+		}
+		if res, err := ApplyPlane(NewPlane[uint16](image.Rect(0, 0, 0, 4)), blur); err != nil || !res.Rect.Empty() {
+			t.Errorf("ApplyPlane of an empty plane: %v", err)
+		}
+	}
+
+	img := randomGray(image.Rect(3, 1, 80, 61), 14)
+	for _, r := range []int{1, 2, 5} {
+		box, err := BoxBlur(img, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				want := 0
+				if (image.Point{x, y}).In(b.Inset(r)) {
+					for j := -r; j <= r; j++ {
+						for i := -r; i <= r; i++ {
+							want += int(img.GrayAt(x+i, y+j).Y)
+						}
+					}
+					n := (2*r + 1) * (2*r + 1)
+					want = (2*want + n) / (2 * n)
+				}
+				if got := int(box.GrayAt(x, y).Y); got != want {
+					t.Fatalf("box %d: (%d, %d) = %d, expected %d", r, x, y, got, want)
+				}
+			}
+		}
+		boxW, _ := BoxBlur(img, r, WithWorkers(4))
+		if !sameGray(box, boxW) {
+			t.Errorf("box %d: 4 workers differ from sequential", r)
+		}
+	}
+
+	//an impulse spreads symmetrically within the radius only
+	impulse := image.NewGray(image.Rect(0, 0, 21, 21))
+	impulse.SetGray(10, 10, color.Gray{Y: 255})
+	gauss, err := GaussianBlur(impulse, 1.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 21; x++ {
+			v := gauss.GrayAt(x, y).Y
+			if v != gauss.GrayAt(20-x, y).Y || v != gauss.GrayAt(y, x).Y {
+				t.Fatalf("Gaussian is not symmetric at (%d, %d)", x, y)
+			}
+			if d := x - 10; (d < -5 || d > 5) && v != 0 {
+				t.Errorf("Gaussian reaches (%d, %d) beyond the radius", x, y)
+			}
+		}
+	}
+	if peak := gauss.GrayAt(10, 10).Y; peak <= gauss.GrayAt(11, 10).Y || float64(peak) > 255/(2*math.Pi*1.5*1.5)+1 {
+		t.Errorf("Gaussian peak %d", peak)
+	}
+	if k := gaussianKernel(0, 2); k.Size != 5 {
+		t.Errorf("Gaussian of radius 2 has size %d", k.Size)
+	}
+
+	//a flat image stays flat with the default pre-smoothing border
+	flat := image.NewGray(image.Rect(0, 0, 30, 30))
+	for i := range flat.Pix {
+		flat.Pix[i] = 77
+	}
+	for k, opt := range []Option{WithBlur(2), WithBoxBlur(3)} {
+		if o := newOptions([]Option{opt}); !sameGray(o.smooth(flat), flat) {
+			t.Errorf("%d: pre-smoothing changed a flat image", k)
+		}
+	}
+
+	//pre-smoothing is Apply on the smoothed image and reduces noise
+	noisy := randomGray(image.Rect(0, 0, 64, 64), 15)
+	smoothed, _ := GaussianBlur(noisy, 1, 0, WithBorder(BorderReflect101))
+	want, _ := Apply(smoothed)
+	got, _ := Apply(noisy, WithBlur(1))
+	if !sameGray(got, want) {
+		t.Errorf("WithBlur differs from Apply on GaussianBlur")
+	}
+	raw, _ := Apply(noisy)
+	sum := func(img *image.Gray) (s int) {
+		for _, v := range img.Pix {
+			s += int(v)
+		}
+		return s
+	}
+	if sum(got) >= sum(raw)/2 {
+		t.Errorf("blurred noise magnitude %d, raw %d", sum(got), sum(raw))
+	}
+	boxed, _ := Apply(noisy, WithBoxBlur(1), WithBackend(BackendAsm))
+	if sum(boxed) >= sum(raw)/2 {
+		t.Errorf("box blurred noise magnitude %d, raw %d", sum(boxed), sum(raw))
+	}
+
+	if _, err := GaussianBlur(img, 0, 0); err == nil {
+		t.Errorf("Gaussian without sigma and radius accepted")
+	}
+	if _, err := BoxBlur(img, 0); err == nil {
+		t.Errorf("box of radius 0 accepted")
+	}
+	for k, opts := range [][]Option{{WithBlur(-1)}, {WithBoxBlur(-2)}, {WithBlurRadius(-1)}} {
+		if _, err := Apply(img, opts...); err == nil {
+			t.Errorf("%d: invalid blur accepted", k)
+		}
+	}
+}
+
+func (s *SobelTS) Test_Laplacian(t *testing.T) {
+	dot := image.NewGray(image.Rect(0, 0, 7, 7))
+	dot.SetGray(3, 3, color.Gray{Y: 100})
+	resp, err := Laplacian(dot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := resp.At(3, 3); v != -800 {
+		t.Errorf("Laplacian of a bright dot %v, expected -800", v)
+	}
+	if v := resp.At(2, 4); v != 100 {
+		t.Errorf("Laplacian next to a bright dot %v, expected 100", v)
+	}
+	abs, _ := Apply(dot, WithKernel(Laplasian))
+	if abs.GrayAt(3, 3).Y != 255 || abs.GrayAt(2, 4).Y != 100 {
+		t.Errorf("Laplasian filter %d, %d, expected 255, 100", abs.GrayAt(3, 3).Y, abs.GrayAt(2, 4).Y)
+	}
+
+	//a bright disk of radius 6
+	disk := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x-32)*(x-32)+(y-32)*(y-32) <= 36 {
+				disk.SetGray(x, y, color.Gray{Y: 200})
+			}
+		}
+	}
+	diskLoG, _ := LoG(disk, 3)
+	diskDoG, _ := DoG(disk, 3, 3*1.6)
+	if diskLoG.At(32, 32) >= 0 || diskDoG.At(32, 32) <= 0 {
+		t.Errorf("disk centre LoG %v, DoG %v", diskLoG.At(32, 32), diskDoG.At(32, 32))
+	}
+	if diskLoG.At(32-12, 32) != diskLoG.At(32+12, 32) || diskLoG.At(32, 32-12) != diskLoG.At(32+12, 32) {
+		t.Errorf("LoG of a disk is not symmetric")
+	}
+	//scale normalised: the disk responds most at sigma close to r/sqrt(2)
+	best, bestSigma := float32(0), 0.0
+	for _, sigma := range []float64{1, 2, 3, 4.2, 6, 8} {
+		resp, _ := LoG(disk, sigma)
+		if v := -resp.At(32, 32); v > best {
+			best, bestSigma = v, sigma
+		}
+	}
+	if bestSigma != 4.2 {
+		t.Errorf("disk of radius 6 responds most at sigma %v", bestSigma)
+	}
+	flat := image.NewGray(image.Rect(0, 0, 40, 40))
+	for i := range flat.Pix {
+		flat.Pix[i] = 90
+	}
+	flatLog, _ := LoG(flat, 2)
+	for _, v := range flatLog.Pix {
+		if math.Abs(float64(v)) > 1e-3 {
+			t.Fatalf("LoG of a flat image %v", v)
+		}
+	}
+
+	zc := ZeroCrossings(&Response{Pix: []float32{-3, -1, 2, 4, 0, -4}, Stride: 6, Rect: image.Rect(0, 0, 6, 1)}, 2.5)
+	if want := []uint8{0, 255, 0, 0, 255, 0}; !reflect.DeepEqual(zc.Pix, want) {
+		t.Errorf("zero crossings %v, expected %v", zc.Pix, want)
+	}
+
+	square := image.NewGray(image.Rect(0, 0, 60, 60))
+	for y := 20; y < 40; y++ {
+		for x := 20; x < 40; x++ {
+			square.SetGray(x, y, color.Gray{Y: 150})
+		}
+	}
+	edges, err := MarrHildreth(square, 1.5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 25; i < 35; i++ {
+		var row, col int
+		for j := 0; j < 60; j++ {
+			if v := edges.GrayAt(j, i).Y; v != 0 {
+				row++
+				if j < 18 || j > 41 || (j > 21 && j < 38) {
+					t.Errorf("zero crossing at (%d, %d) away from the square", j, i)
+				}
+			}
+			col += int(edges.GrayAt(i, j).Y) / 255
+		}
+		if row != 2 || col != 2 {
+			t.Errorf("row %d has %d zero crossings, column %d has %d, expected 2", i, row, i, col)
+		}
+	}
+
+	rnd := randomGray(image.Rect(2, 3, 90, 71), 16)
+	crop, _ := LoG(rnd, 1, WithBorder(BorderCrop))
+	if crop.Bounds() != rnd.Bounds().Inset(4) { //LoG reaches 4 sigma
+		t.Errorf("cropped LoG bounds %v", crop.Bounds())
+	}
+	seq, _ := DoG(rnd, 1, 2, WithBorder(BorderReflect))
+	par, _ := DoG(rnd, 1, 2, WithBorder(BorderReflect), WithWorkers(5))
+	if !reflect.DeepEqual(seq, par) {
+		t.Errorf("DoG: 5 workers differ from sequential")
+	}
+	if _, err := LoG(rnd, 0); err == nil {
+		t.Errorf("LoG sigma 0 accepted")
+	}
+	if _, err := DoG(rnd, 2, 1); err == nil {
+		t.Errorf("DoG with sigma2 < sigma1 accepted")
+	}
+}
+
+func (s *SobelTS) Test_Convolution(t *testing.T) {
+	dot := image.NewGray(image.Rect(0, 0, 7, 7))
+	for i := range dot.Pix {
+		dot.Pix[i] = 20
+	}
+	dot.SetGray(3, 3, color.Gray{Y: 40})
+	sharp, err := Apply(dot, WithKernel(Sharpen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sharp.GrayAt(3, 3).Y != 120 || sharp.GrayAt(3, 2).Y != 0 || sharp.GrayAt(2, 2).Y != 20 {
+		t.Errorf("sharpened dot %d, %d, %d, expected 120, 0, 20",
+			sharp.GrayAt(3, 3).Y, sharp.GrayAt(3, 2).Y, sharp.GrayAt(2, 2).Y)
+	}
+	if legacy := FilterGray(dot, Sharpen); !sameGray(legacy, sharp) {
+		t.Errorf("FilterGray Sharpen differs from Apply")
+	}
+	//0 and 120 mapped from -255..255
+	signed, _ := Apply(dot, WithKernel(Sharpen), WithRange(-255, 255))
+	if signed.GrayAt(3, 2).Y != 128 || signed.GrayAt(3, 3).Y != 188 {
+		t.Errorf("signed sharpened dot %d, %d, expected 128, 188", signed.GrayAt(3, 2).Y, signed.GrayAt(3, 3).Y)
+	}
+
+	img := randomGray(image.Rect(1, 1, 50, 40), 17)
+	k, _ := NewKernel(3, []int{0, 1, 0, 1, -2, 0, 0, 0, 1})
+	conv, err := Convolve(img, k, WithBorder(BorderReplicate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := Apply(img, WithKernels(k, nil), WithConvolution(), WithBorder(BorderReplicate))
+	if !sameGray(conv.(*image.Gray), want) {
+		t.Errorf("Convolve differs from Apply in convolution mode")
+	}
+	//the options of the caller are left alone, even with spare capacity
+	opts := make([]Option, 1, 3)
+	opts[0] = WithBorder(BorderReplicate)
+	if _, err := Convolve(img, k, opts...); err != nil {
+		t.Fatal(err)
+	}
+	if spare := opts[:3]; spare[1] != nil || spare[2] != nil {
+		t.Errorf("Convolve wrote into the options of the caller")
+	}
+
+	//colour images are filtered per channel
+	rgba := image.NewRGBA(img.Bounds())
+	inv := image.NewGray(img.Bounds())
+	for i, v := range img.Pix {
+		inv.Pix[i] = 255 - v
+	}
+	for y := 1; y < 40; y++ {
+		for x := 1; x < 50; x++ {
+			rgba.SetRGBA(x, y, color.RGBA{R: img.GrayAt(x, y).Y, G: inv.GrayAt(x, y).Y, B: 7, A: 255})
+		}
+	}
+	for name, filter := range map[string]func(image.Image, ...Option) (image.Image, error){
+		"Sharpen": SharpenImage,
+		"Emboss":  Emboss,
+		"Unsharp": func(img image.Image, opts ...Option) (image.Image, error) { return UnsharpMask(img, 1.5, 1, opts...) },
+	} {
+		colour, err := filter(rgba, WithBorder(BorderCrop))
+		if err != nil {
+			t.Fatal(err)
+		}
+		red, _ := filter(img, WithBorder(BorderCrop))
+		green, _ := filter(inv, WithBorder(BorderCrop))
+		res := colour.(*image.RGBA)
+		if res.Bounds() != red.Bounds() {
+			t.Fatalf("%s: colour bounds %v, gray %v", name, res.Bounds(), red.Bounds())
+		}
+		b := res.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := res.RGBAAt(x, y)
+				if c.R != red.(*image.Gray).GrayAt(x, y).Y || c.G != green.(*image.Gray).GrayAt(x, y).Y || c.A != 255 {
+					t.Fatalf("%s: (%d, %d) = %v", name, x, y, c)
+				}
+			}
+		}
+	}
+
+	//filters that keep a flat image flat
+	flat := image.NewGray(image.Rect(0, 0, 20, 20))
+	for i := range flat.Pix {
+		flat.Pix[i] = 100
+	}
+	for name, filter := range map[string]func(image.Image, ...Option) (image.Image, error){
+		"Sharpen": SharpenImage,
+		"Emboss":  Emboss,
+		"Unsharp": func(img image.Image, opts ...Option) (image.Image, error) { return UnsharpMask(img, 2, 2, opts...) },
+	} {
+		if res, _ := filter(flat, WithBorder(BorderReflect)); !sameGray(res.(*image.Gray), flat) {
+			t.Errorf("%s changed a flat image", name)
+		}
+	}
+
+	//unsharp mask overshoots on both sides of a step
+	step := image.NewGray(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			step.SetGray(x, y, color.Gray{Y: uint8(100 + 50*(x/10))})
+		}
+	}
+	unsharp, _ := UnsharpMask(step, 1, 1.5, WithBorder(BorderReplicate))
+	if u := unsharp.(*image.Gray); u.GrayAt(9, 10).Y >= 100 || u.GrayAt(10, 10).Y <= 150 || u.GrayAt(2, 10).Y != 100 {
+		t.Errorf("unsharp step %d, %d, %d", u.GrayAt(2, 10).Y, u.GrayAt(9, 10).Y, u.GrayAt(10, 10).Y)
+	}
+	same, _ := UnsharpMask(step, 0, 1.5, WithBorder(BorderReplicate))
+	if !sameGray(same.(*image.Gray), step) {
+		t.Errorf("unsharp mask of amount 0 changed the image")
+	}
+
+	resp, _ := Laplacian(dot)
+	if img, err := resp.Image(WithRange(-255, 255)); err != nil {
+		t.Error(err)
+	} else if v := img.GrayAt(3, 3).Y; v != 48 {
+		t.Errorf("Laplacian image %d, expected 48", v)
+	}
+
+	for k, opts := range [][]Option{
+		{WithKernel(Sharpen), WithBackend(BackendAsm)},
+		{WithConvolution(), WithBackend(BackendAsm)},
+		{WithConvolution(), WithMapping(MappingNormalize)},
+		{WithConvolution(), WithKernels(nil, k)},
+		{WithConvolution(), WithKernel(Kirsch)},
+	} {
+		if _, err := Apply(img, opts...); err == nil {
+			t.Errorf("%d: invalid convolution accepted", k)
+		}
+	}
+	if _, err := UnsharpMask(img, -1, 1); err == nil {
+		t.Errorf("negative unsharp amount accepted")
+	}
+}
+
+func (s *SobelTS) Test_ColorGradient(t *testing.T) {
+	//red on the left, green of the same luminance on the right
+	red := color.RGBA{R: 255, A: 255}
+	green := color.RGBA{A: 255}
+	for g := 0; g < 256; g++ {
+		green.G = uint8(g)
+		if color.GrayModel.Convert(green) == color.GrayModel.Convert(red) {
+			break
+		}
+	}
+	if color.GrayModel.Convert(green) != color.GrayModel.Convert(red) {
+		t.Fatalf("no green of the red luminance")
+	}
+	b := image.Rect(0, 0, 20, 10)
+	rgba, nrgba := image.NewRGBA(b), image.NewNRGBA(b)
+	ycbcr := image.NewYCbCr(b, image.YCbCrSubsampleRatio444)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 20; x++ {
+			c := red
+			if x >= 10 {
+				c = green
+			}
+			rgba.SetRGBA(x, y, c)
+			nrgba.Set(x, y, c)
+			i := ycbcr.YOffset(x, y)
+			ycbcr.Y[i], ycbcr.Cb[i], ycbcr.Cr[i] = color.RGBToYCbCr(c.R, c.G, c.B)
+		}
+	}
+	if edges, _ := Apply(rgba); !sameGray(edges, image.NewGray(b)) {
+		t.Fatalf("isoluminant colours have gray edges")
+	}
+	for _, img := range []image.Image{rgba, nrgba, ycbcr} {
+		for _, mode := range []ColorMode{ColorDiZenzo, ColorMaxChannel} {
+			g, err := ColorGradient(img, mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m := g.Magnitude(9, 5); m < 4*float64(green.G) {
+				t.Errorf("%T %v: magnitude %v at the colour edge", img, mode, m)
+			}
+			if o := g.Orientation(9, 5); math.Abs(o) > 1e-6 && math.Abs(math.Abs(o)-math.Pi) > 1e-6 {
+				t.Errorf("%T %v: orientation %v of a vertical edge", img, mode, o)
+			}
+			if m := g.Magnitude(4, 5); m != 0 {
+				t.Errorf("%T %v: magnitude %v inside a colour", img, mode, m)
+			}
+		}
+	}
+
+	//on gray images Di Zenzo is sqrt(3) times the gray gradient, the
+	//strongest channel is the gray gradient itself
+	gray := randomGray(image.Rect(0, 0, 30, 30), 18)
+	want, _ := ApplyGradient(gray, WithBorder(BorderCrop))
+	dz, _ := ColorGradient(gray, ColorDiZenzo, WithBorder(BorderCrop))
+	mc, _ := ColorGradient(gray, ColorMaxChannel, WithBorder(BorderCrop))
+	if dz.Bounds() != want.Bounds() {
+		t.Fatalf("colour gradient bounds %v, expected %v", dz.Bounds(), want.Bounds())
+	}
+	for y := 1; y < 29; y++ {
+		for x := 1; x < 29; x++ {
+			wx, wy := want.At(x, y)
+			if dx, dy := mc.At(x, y); dx != wx || dy != wy {
+				t.Fatalf("max channel (%v, %v), expected (%v, %v)", dx, dy, wx, wy)
+			}
+			dx, dy := dz.At(x, y)
+			if math.Abs(float64(dx)-math.Sqrt(3)*float64(wx)) > 1e-3 || math.Abs(float64(dy)-math.Sqrt(3)*float64(wy)) > 1e-3 {
+				t.Fatalf("Di Zenzo (%v, %v), expected sqrt(3) * (%v, %v)", dx, dy, wx, wy)
+			}
+		}
+	}
+	if _, err := ColorGradient(gray, ColorMode(5)); err == nil {
+		t.Errorf("unknown color mode accepted")
+	}
+	if _, err := ColorGradient(gray, ColorMaxChannel, WithKernel(Kirsch)); err == nil {
+		t.Errorf("color gradient accepted Kirsch")
+	}
+}
+
+func (s *SobelTS) Test_ColorSpaces(t *testing.T) {
+	rnd := rand.New(rand.NewSource(19))
+	//8-bit Lab loses the dark channels of saturated colours
+	tolerance := map[ColorSpace]int{SpaceRGB: 0, SpaceYCbCr: 2, SpaceHSV: 3, SpaceLab: 25}
+	for space, tol := range tolerance {
+		for i := 0; i < 2000; i++ {
+			r, g, b := uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256))
+			c0, c1, c2 := toSpace(space, r, g, b)
+			r2, g2, b2 := fromSpace(space, c0, c1, c2)
+			for _, d := range []int{int(r) - int(r2), int(g) - int(g2), int(b) - int(b2)} {
+				if d < -tol || d > tol {
+					t.Fatalf("%v: (%d, %d, %d) comes back as (%d, %d, %d)", space, r, g, b, r2, g2, b2)
+				}
+			}
+		}
+	}
+	if h, s, v := rgbToHSV(0, 0, 255); h != 171 || s != 255 || v != 255 {
+		t.Errorf("HSV of blue (%d, %d, %d)", h, s, v)
+	}
+	if l, a, b := rgbToLab(255, 255, 255); l != 255 || a != 128 || b != 128 {
+		t.Errorf("Lab of white (%d, %d, %d)", l, a, b)
+	}
+
+	//red and green of the same luminance (and Cb): no edge in Y, an edge in Cr and hue
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 20; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 10 {
+				c = color.RGBA{G: 130, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	ycc, err := FilterChannels(img, Sobel, SpaceYCbCr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ycc[0].GrayAt(9, 5).Y > 8 || ycc[1].GrayAt(9, 5).Y > 8 || ycc[2].GrayAt(9, 5).Y < 100 {
+		t.Errorf("YCbCr edges %d, %d, %d", ycc[0].GrayAt(9, 5).Y, ycc[1].GrayAt(9, 5).Y, ycc[2].GrayAt(9, 5).Y)
+	}
+	hue, _ := FilterChannels(img, Sobel, SpaceHSV, WithChannels(0))
+	if hue[0] == nil || hue[1] != nil || hue[2] != nil || hue[0].GrayAt(9, 5).Y != 255 {
+		t.Errorf("hue channel only: %v", hue)
+	}
+
+	//per channel RGB edges
+	rgb, _ := FilterChannels(img, Sobel, SpaceRGB, WithBorder(BorderCrop))
+	red := image.NewGray(img.Bounds())
+	for i := range red.Pix {
+		red.Pix[i] = img.Pix[4*i]
+	}
+	if want, _ := Apply(red, WithBorder(BorderCrop)); !sameGray(rgb[0], want) {
+		t.Errorf("red channel differs from Apply")
+	}
+	edges, err := FilterColor(img, Sobel, SpaceRGB, WithBorder(BorderCrop))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edges.Bounds() != rgb[0].Bounds() {
+		t.Fatalf("recombined bounds %v, expected %v", edges.Bounds(), rgb[0].Bounds())
+	}
+	for y := 1; y < 9; y++ {
+		for x := 1; x < 19; x++ {
+			c := edges.RGBAAt(x, y)
+			if c.R != rgb[0].GrayAt(x, y).Y || c.G != rgb[1].GrayAt(x, y).Y || c.B != rgb[2].GrayAt(x, y).Y || c.A != 255 {
+				t.Fatalf("recombined (%d, %d) = %v", x, y, c)
+			}
+		}
+	}
+
+	//sharpening the lightness of a gray image keeps it gray
+	gray := randomGray(image.Rect(0, 0, 16, 16), 20)
+	sharp, err := FilterColor(gray, Sharpen, SpaceLab, WithChannels(0), WithBorder(BorderReplicate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(sharp.Pix); i += 4 {
+		if d := int(sharp.Pix[i]) - int(sharp.Pix[i+2]); d < -2 || d > 2 {
+			t.Fatalf("sharpened lightness is not gray: %v", sharp.Pix[i:i+4])
+		}
+	}
+
+	if _, err := FilterChannels(img, Sobel, ColorSpace(9)); err == nil {
+		t.Errorf("unknown color space accepted")
+	}
+	if _, err := FilterColor(img, Sobel, SpaceRGB, WithChannels(3)); err == nil {
+		t.Errorf("channel 3 accepted")
+	}
+}
+
+func (s *SobelTS) Test_ToGrayscale(t *testing.T) {
+	rnd := rand.New(rand.NewSource(21))
+	b := image.Rect(3, 5, 40, 31)
+	rgba, nrgba := image.NewRGBA(b), image.NewNRGBA(b)
+	ycbcr := image.NewYCbCr(b, image.YCbCrSubsampleRatio420)
+	paletted := image.NewPaletted(b, color.Palette{color.Black, color.White, color.RGBA{R: 200, G: 10, B: 90, A: 255}})
+	gray := image.NewGray(b)
+	rnd.Read(rgba.Pix)
+	rnd.Read(nrgba.Pix)
+	rnd.Read(ycbcr.Y)
+	rnd.Read(ycbcr.Cb)
+	rnd.Read(ycbcr.Cr)
+	rnd.Read(gray.Pix)
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(rnd.Intn(3))
+	}
+	//RGBA must stay premultiplied
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		rgba.Pix[i+3] = 255
+	}
+	sub := image.Rect(4, 7, 30, 29)
+	images := []image.Image{
+		rgba, nrgba, ycbcr, paletted, gray,
+		rgba.SubImage(sub), nrgba.SubImage(sub), gray.SubImage(sub), ycbcr.SubImage(sub),
+		image.NewRGBA64(b), //the generic path
+	}
+	for _, img := range images {
+		for l := LumaBT601; l <= LumaBlue; l++ {
+			got := ToGrayscaleLuma(img, l)
+			if got.Bounds() != img.Bounds() {
+				t.Fatalf("%T: bounds %v, expected %v", img, got.Bounds(), img.Bounds())
+			}
+			wr, wg, wb := l.weights()
+			for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+				for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+					r, g, bl, _ := img.At(x, y).RGBA()
+					want := uint8((wr*r + wg*g + wb*bl + 1<<15) >> 24)
+					if yc, ok := img.(*image.YCbCr); ok && l == LumaBT601 {
+						want = yc.Y[yc.YOffset(x, y)]
+					} else if l == LumaBT601 {
+						want = color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+					}
+					if v := got.GrayAt(x, y).Y; v != want {
+						t.Fatalf("%T %v: (%d, %d) = %d, expected %d", img, l, x, y, v, want)
+					}
+				}
+			}
+		}
+	}
+	if v := ToGrayscaleLuma(rgba, LumaGreen).GrayAt(10, 10).Y; v != rgba.RGBAAt(10, 10).G {
+		t.Errorf("green channel %d, expected %d", v, rgba.RGBAAt(10, 10).G)
+	}
+
+	dst := image.NewGray(b)
+	if err := ToGrayscaleInto(dst, rgba, LumaBT709); err != nil {
+		t.Fatal(err)
+	}
+	if !sameGray(dst, ToGrayscaleLuma(rgba, LumaBT709)) {
+		t.Errorf("ToGrayscaleInto differs from ToGrayscaleLuma")
+	}
+	if err := ToGrayscaleInto(dst, rgba.SubImage(sub), LumaBT601); err == nil {
+		t.Errorf("different bounds accepted")
+	}
+	if err := ToGrayscaleInto(dst, rgba, Luma(10)); err == nil {
+		t.Errorf("unknown luma accepted")
+	}
+
+	edges, _ := Apply(rgba, WithLuma(LumaRed))
+	red, _ := FilterChannels(rgba, Sobel, SpaceRGB, WithChannels(0))
+	if !sameGray(edges, red[0]) {
+		t.Errorf("Apply with red luma differs from the red channel")
+	}
+	if _, err := Apply(rgba, WithLuma(Luma(-1))); err == nil {
+		t.Errorf("unknown luma accepted by Apply")
+	}
+}
+
+func (s *SobelTS) Benchmark_ToGrayscale(b *testing.B) {
+	rgba := image.NewRGBA(s.img.Bounds())
+	for i := 0; i < b.N; i++ {
+		ToGrayscale(rgba)
+	}
+}
+
+func (s *SobelTS) Test_Asm(t *testing.T) {
+	//every instruction set up to the detected one, widths cover the
+	//blocks of 8 and 16 pixels and the go tails after them
+	detected := asm.Level
+	defer func() { asm.Level = detected }()
+	levels := []string{asm.Go}
+	switch detected {
+	case asm.AVX2:
+		levels = append(levels, asm.SSE41, asm.AVX2)
+	case asm.SSE41:
+		levels = append(levels, asm.SSE41)
+	}
+	t.Logf("detected %s", detected)
+	var images []*image.Gray
+	for w := 1; w <= 40; w++ {
+		images = append(images, randomGray(image.Rect(-3, 2, w-3, 7), int64(w)))
+	}
+	images = append(images, s.img, s.img.SubImage(image.Rect(33, 17, 290, 211)).(*image.Gray))
+	//extreme responses: ±1020 and magnitudes above 255
+	stripes := image.NewGray(image.Rect(0, 0, 37, 9))
+	for i := range stripes.Pix {
+		if i%37%2 == 0 {
+			stripes.Pix[i] = 255
+		}
+	}
+	images = append(images, stripes)
+
+	for _, level := range levels {
+		asm.Level = level
+		for _, img := range images {
+			want, err := Apply(img, WithBackend(BackendMath))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := FilterGrayAsm(img); !sameGray(got, want) {
+				t.Fatalf("%s %v: differs from the math backend", level, img.Bounds())
+			}
+			for _, opts := range [][]Option{
+				{WithMagnitude(MagnitudeL1)},
+				{WithRange(10, 600), WithBorder(BorderReflect101)},
+				{WithMapping(MappingNormalize), WithWorkers(3)},
+			} {
+				want, err := Apply(img, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := Apply(img, append(opts, WithBackend(BackendAsm))...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !sameGray(got, want) {
+					t.Fatalf("%s %v: differs from the go backend", level, img.Bounds())
+				}
+			}
+			want16, _ := ApplyGradient(img, WithBorder(BorderWrap))
+			got16, err := ApplyGradient(img, WithBorder(BorderWrap), WithBackend(BackendAsm))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got16, want16) {
+				t.Fatalf("%s %v: gradient differs from the go backend", level, img.Bounds())
+			}
+		}
+	}
+
+	if _, err := Apply(s.img, WithBackend(BackendAsm), WithKernel(Prewitt)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("asm backend runs 3x3 Sobel only: %v", err)
+	}
+}
+
+func (s *SobelTS) Test_Planes(t *testing.T) {
+	img := s.img.SubImage(image.Rect(100, 80, 221, 173)).(*image.Gray)
+	b := img.Bounds()
+	gray16 := image.NewGray16(b)
+	floats := NewPlane[float32](b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			v := img.GrayAt(x, y).Y
+			gray16.SetGray16(x, y, color.Gray16{Y: uint16(v) * 257})
+			floats.Set(x, y, float32(v))
+		}
+	}
+	if got := PlaneGray16(Gray16Plane(gray16)); !reflect.DeepEqual(got, gray16) {
+		t.Fatalf("Gray16 doesn't survive the round trip through a plane")
+	}
+
+	//integral kernels give the same responses on every pixel type
+	for _, flt := range []FilterType{Sobel, Laplasian, Prewitt, Roberts, Sobel7, Kirsch, Sharpen} {
+		want, err := ApplyPlane(GrayPlane(img), WithKernel(flt), WithBorder(BorderReflect))
+		if err != nil {
+			t.Fatalf("%v: %v", flt, err)
+		}
+		got, err := ApplyPlane(floats, WithKernel(flt), WithBorder(BorderReflect), WithWorkers(3))
+		if err != nil {
+			t.Fatalf("%v: %v", flt, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: float32 plane differs from the 8-bit one", flt)
+		}
+		got16, err := ApplyPlane(Gray16Plane(gray16), WithKernel(flt), WithBorder(BorderReflect))
+		if err != nil {
+			t.Fatalf("%v: %v", flt, err)
+		}
+		for i, v := range want.Pix {
+			if math.Abs(float64(got16.Pix[i])-257*float64(v)) > 1e-6*math.Abs(257*float64(v))+1e-3 {
+				t.Fatalf("%v: 16-bit response %v, expected 257 * %v", flt, got16.Pix[i], v)
+			}
+		}
+	}
+
+	//the magnitude is the one of the gradient, unclipped
+	g, err := ApplyGradient(img, WithBorder(BorderCrop))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mag, err := ApplyPlane(GrayPlane(img), WithBorder(BorderCrop))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mag.Bounds() != b.Inset(1) {
+		t.Fatalf("cropped bounds %v, expected %v", mag.Bounds(), b.Inset(1))
+	}
+	for y := mag.Rect.Min.Y; y < mag.Rect.Max.Y; y++ {
+		for x := mag.Rect.Min.X; x < mag.Rect.Max.X; x++ {
+			if v := float64(mag.At(x, y)); math.Abs(v-g.Magnitude(x, y)) > 1e-3 {
+				t.Fatalf("(%d, %d) = %v, expected %v", x, y, v, g.Magnitude(x, y))
+			}
+		}
+	}
+	g16, err := ApplyGradientPlane(Gray16Plane(gray16), WithBorder(BorderCrop))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dx, dy := g.At(120, 100); g16.Dx[g16.Offset(120, 100)] != 257*dx || g16.Dy[g16.Offset(120, 100)] != 257*dy {
+		t.Errorf("16-bit gradient %v, expected 257 * %v", g16.Dx[g16.Offset(120, 100)], dx)
+	}
+
+	//16-bit output is mapped like 8-bit one, to 0..65535
+	want, err := Apply(img, WithBackend(BackendMath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ApplyGray16(gray16, WithScale(1.0/257))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			v, w := got.Gray16At(x, y).Y, want.GrayAt(x, y).Y
+			if w < 255 && v != uint16(w) || w == 255 && v < 255 {
+				t.Fatalf("(%d, %d) = %d, expected %d", x, y, v, w)
+			}
+		}
+	}
+	got, err = ApplyGray16(gray16, WithMapping(MappingNormalize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var max uint16
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if v := got.Gray16At(x, y).Y; v > max {
+				max = v
+			}
+		}
+	}
+	if max != 65535 {
+		t.Errorf("normalized maximum %d, expected 65535", max)
+	}
+
+	//smoothing keeps flat planes flat, rounding included
+	flat := NewPlane[uint16](image.Rect(0, 0, 20, 20))
+	for i := range flat.Pix {
+		flat.Pix[i] = 40000
+	}
+	for _, opt := range []Option{WithBlur(1.5), WithBoxBlur(2)} {
+		smooth, err := ApplyPlane(flat, opt, WithBorder(BorderReplicate))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range smooth.Pix {
+			if math.Abs(float64(v)) > 1e-2 {
+				t.Fatalf("flat plane responds %v", v)
+			}
+		}
+	}
+
+	if _, err := ApplyPlane(floats, WithRange(0, 100)); err == nil {
+		t.Errorf("float results can't be mapped")
+	}
+	if _, err := ApplyGray16(gray16, WithBackend(BackendAsm)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("asm backend on 16-bit pixels: %v", err)
+	}
+	if _, err := ApplyGradientPlane(floats, WithKernel(Kirsch)); err == nil {
+		t.Errorf("compass filters have no gradient")
+	}
+}
+
+// wrapBackend is a backend of another package: it runs the math one
+// through the Backend interface and counts the calls
+type wrapBackend struct {
+	name  string
+	speed int
+	calls int64
+	fail  error
+}
+
+func init() {
+	if err := RegisterBackend(&wrapBackend{name: "wrap", speed: 1}); err != nil {
+		panic(err)
+	}
+}
+
+func (b *wrapBackend) math() Backend {
+	m, _ := LookupBackend(BackendMath)
+	return m
+}
+
+func (b *wrapBackend) Name() string {
+	return b.name
+}
+
+func (b *wrapBackend) Capabilities() Capabilities {
+	caps := b.math().Capabilities()
+	caps.Speed = b.speed
+	return caps
+}
+
+func (b *wrapBackend) Supports(flt FilterType) bool {
+	return b.math().Supports(flt)
+}
+
+func (b *wrapBackend) Gradient(img *image.Gray, f *FilterSpec, g *Gradient) error {
+	atomic.AddInt64(&b.calls, 1)
+	if b.fail != nil {
+		return b.fail
+	}
+	return b.math().Gradient(img, f, g)
+}
+
+func (b *wrapBackend) Magnitude(img *image.Gray, f *FilterSpec) (*image.Gray, error) {
+	atomic.AddInt64(&b.calls, 1)
+	if b.fail != nil {
+		return nil, b.fail
+	}
+	return b.math().Magnitude(img, f)
+}
+
+func (s *SobelTS) Test_Backends(t *testing.T) {
+	list := Backends()
+	for i := 1; i < len(list); i++ {
+		if list[i-1].Capabilities().Speed < list[i].Capabilities().Speed {
+			t.Errorf("%s is listed before the faster %s", list[i-1].Name(), list[i].Name())
+		}
+	}
+	for _, name := range []string{BackendGo, BackendMath, BackendAsm, "wrap"} {
+		if b, err := LookupBackend(name); err != nil || b.Name() != name {
+			t.Errorf("%s: lookup %v, %v", name, b, err)
+		}
+	}
+	if _, err := LookupBackend("nope"); err == nil {
+		t.Errorf("unknown backend found")
+	}
+	if b, _ := LookupBackend(BackendAsm); b.Capabilities().Instructions != asm.Level {
+		t.Errorf("asm backend runs %q, the CPU level is %q", b.Capabilities().Instructions, asm.Level)
+	}
+	for _, b := range []Backend{nil, &wrapBackend{name: BackendMath}, &wrapBackend{}, &wrapBackend{name: BackendFastest}} {
+		if err := RegisterBackend(b); err == nil {
+			t.Errorf("%v registered", b)
+		}
+	}
+
+	//fastest: the first of the list running the filter
+	for _, tc := range []struct {
+		opts []Option
+		want string
+	}{
+		{[]Option{WithKernel(Prewitt)}, BackendMath},
+		{[]Option{WithKernel(Kirsch)}, BackendMath},
+		{[]Option{WithKernels(sobelX, nil)}, BackendMath},
+		{[]Option{WithOrder(2)}, BackendMath},
+		{[]Option{WithConvolution()}, BackendMath},
+		{[]Option{WithKernel(Sobel)}, list[0].Name()},
+	} {
+		b, err := FastestBackend(tc.opts...)
+		if err != nil || b.Name() != tc.want {
+			t.Errorf("%d options: fastest %v, %v, expected %s", len(tc.opts), b, err, tc.want)
+			continue
+		}
+		got, err := Apply(s.img, append(tc.opts, WithBackend(BackendFastest))...)
+		want, _ := Apply(s.img, append(tc.opts, WithBackend(tc.want))...)
+		if err != nil || !sameGray(got, want) {
+			t.Errorf("%d options: fastest differs from %s: %v", len(tc.opts), tc.want, err)
+		}
+	}
+	if _, err := FastestBackend(WithKernel(FilterType(99))); err == nil {
+		t.Errorf("fastest backend of an unknown kernel")
+	}
+	p16 := Gray16Plane(image.NewGray16(image.Rect(0, 0, 8, 8)))
+	if _, err := ApplyPlane(p16, WithBackend(BackendFastest)); err != nil {
+		t.Errorf("fastest backend of a 16-bit plane: %v", err)
+	}
+
+	//a backend of another package runs with borders, workers and mappings
+	wrap, _ := LookupBackend("wrap")
+	rnd := randomGray(image.Rect(3, 1, 67, 90), 23)
+	for _, opts := range [][]Option{
+		{WithBorder(BorderReflect), WithWorkers(4)},
+		{WithKernel(Kirsch), WithBorder(BorderCrop)},
+		{WithKernel(Sobel5), WithMapping(MappingNormalize)},
+		{WithKernel(Sharpen), WithRange(-255, 255)},
+	} {
+		calls := atomic.LoadInt64(&wrap.(*wrapBackend).calls)
+		want, _ := Apply(rnd, append(opts, WithBackend(BackendMath))...)
+		got, err := Apply(rnd, append(opts, WithBackend("wrap"))...)
+		if err != nil || !sameGray(got, want) || got.Bounds() != want.Bounds() {
+			t.Errorf("%d options: wrap backend differs from math: %v", len(opts), err)
+		}
+		if atomic.LoadInt64(&wrap.(*wrapBackend).calls) == calls {
+			t.Errorf("%d options: wrap backend not called", len(opts))
+		}
+	}
+	gw, err := ApplyGradient(rnd, WithBackend("wrap"), WithWorkers(3))
+	gm, _ := ApplyGradient(rnd, WithBackend(BackendMath))
+	if err != nil || !reflect.DeepEqual(gw, gm) {
+		t.Errorf("wrap gradient differs from math: %v", err)
+	}
+
+	//backend errors are returned, unsupported filters are not run
+	errFail := errors.New("fail")
+	fail := &wrapBackend{name: "fail", fail: errFail}
+	o := newOptions([]Option{WithWorkers(4)})
+	if _, err := applyBackend(fail, rnd, &o); err != errFail {
+		t.Errorf("magnitude error %v", err)
+	}
+	if _, err := gradientBackend(fail, rnd, &o); err != errFail {
+		t.Errorf("gradient error %v", err)
+	}
+	f, _ := NewFilterSpec(WithKernel(Prewitt))
+	if b, _ := LookupBackend(BackendAsm); b != nil {
+		if _, err := b.Magnitude(rnd, f); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("asm ran Prewitt: %v", err)
+		}
+	}
+}
+
+func sameGray(a, b *image.Gray) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if a.GrayAt(x, y) != b.GrayAt(x, y) {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 func (s *SobelTS) Benchmark_SqrtI(b *testing.B) {
@@ -144,12 +2339,6 @@ func (s *SobelTS) Benchmark_SqrtFloorFast(b *testing.B) {
 	}
 }
 
-func (s *SobelTS) Benchmark_SqrtFloorC(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		FloorSqrtC(sqrtFrom)
-	}
-}
-
 func (s *SobelTS) Benchmark_SqrtMath(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		math.Sqrt(float64(sqrtFrom))
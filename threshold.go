@@ -0,0 +1,172 @@
+package sobel
+
+import (
+	"fmt"
+	"image"
+)
+
+// ThresholdMethod selects how Threshold chooses the threshold
+type ThresholdMethod int
+
+const (
+	ThresholdFixed      ThresholdMethod = iota //the value given, 0..255
+	ThresholdOtsu                              //maximum between class variance
+	ThresholdPercentile                        //the given percentile of the pixels, 0..100
+	ThresholdTriangle                          //farthest histogram bin from the peak to tail line
+)
+
+func (m ThresholdMethod) String() string {
+	switch m {
+	case ThresholdFixed:
+		return "Fixed"
+	case ThresholdOtsu:
+		return "Otsu"
+	case ThresholdPercentile:
+		return "Percentile"
+	case ThresholdTriangle:
+		return "Triangle"
+	}
+	return fmt.Sprintf("ThresholdMethod(%d)", int(m))
+}
+
+// Threshold turns a magnitude image into a binary edge map: pixels
+// greater than the threshold become 255, the rest 0. The threshold is
+// chosen by method from the histogram of every pixel of grayImg (use
+// BorderCrop so that the frame of 0 doesn't count) and returned as well,
+// param is the value of ThresholdFixed and the percentile of
+// ThresholdPercentile, the others ignore it.
+func Threshold(grayImg *image.Gray, method ThresholdMethod, param float64) (binary *image.Gray, threshold uint8, err error) {
+	switch method {
+	case ThresholdFixed:
+		if !(param >= 0 && param <= 255) {
+			return nil, 0, fmt.Errorf("sobel: threshold %v is not in 0..255", param)
+		}
+		threshold = uint8(param)
+	case ThresholdOtsu:
+		threshold = otsuThreshold(histogram(grayImg))
+	case ThresholdPercentile:
+		if !(param >= 0 && param <= 100) {
+			return nil, 0, fmt.Errorf("sobel: percentile %v is not in 0..100", param)
+		}
+		threshold = percentileThreshold(histogram(grayImg), param)
+	case ThresholdTriangle:
+		threshold = triangleThreshold(histogram(grayImg))
+	default:
+		return nil, 0, fmt.Errorf("sobel: unknown threshold method %v", method)
+	}
+	return binarize(grayImg, threshold), threshold, nil
+}
+
+func histogram(grayImg *image.Gray) (hist [256]int) {
+	b := grayImg.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := grayImg.PixOffset(b.Min.X, y)
+		for _, v := range grayImg.Pix[i : i+b.Dx()] {
+			hist[v]++
+		}
+	}
+	return hist
+}
+
+func binarize(grayImg *image.Gray, threshold uint8) *image.Gray {
+	b := grayImg.Bounds()
+	binary := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := grayImg.PixOffset(b.Min.X, y)
+		o := binary.PixOffset(b.Min.X, y)
+		for x, v := range grayImg.Pix[i : i+b.Dx()] {
+			if v > threshold {
+				binary.Pix[o+x] = 255
+			}
+		}
+	}
+	return binary
+}
+
+// otsuThreshold returns t maximizing the variance between the pixels up
+// to t and the ones above it
+func otsuThreshold(hist [256]int) uint8 {
+	var total, sum float64
+	for v, n := range hist {
+		total += float64(n)
+		sum += float64(v * n)
+	}
+	var wB, sumB, best float64
+	t := 0
+	for v, n := range hist {
+		wB += float64(n)
+		if wB == 0 {
+			continue
+		}
+		wF := total - wB
+		if wF == 0 {
+			break
+		}
+		sumB += float64(v * n)
+		mB, mF := sumB/wB, (sum-sumB)/wF
+		if between := wB * wF * (mB - mF) * (mB - mF); between > best {
+			best, t = between, v
+		}
+	}
+	return uint8(t)
+}
+
+// percentileThreshold returns the smallest t such that p percent of the
+// pixels are t or less
+func percentileThreshold(hist [256]int, p float64) uint8 {
+	var total int
+	for _, n := range hist {
+		total += n
+	}
+	limit := p / 100 * float64(total)
+	sum := 0
+	for v, n := range hist {
+		sum += n
+		if float64(sum) >= limit {
+			return uint8(v)
+		}
+	}
+	return 255
+}
+
+// triangleThreshold draws a line from the histogram peak to the end of
+// its longer tail and returns the bin farthest below that line
+func triangleThreshold(hist [256]int) uint8 {
+	left, right := 0, 255
+	for left < 255 && hist[left] == 0 {
+		left++
+	}
+	for right > 0 && hist[right] == 0 {
+		right--
+	}
+	if left >= right {
+		return uint8(left)
+	}
+	//the line ends on the first empty bin past the tail
+	if left > 0 {
+		left--
+	}
+	if right < 255 {
+		right++
+	}
+	peak := left
+	for v := left; v <= right; v++ {
+		if hist[v] > hist[peak] {
+			peak = v
+		}
+	}
+	end, step := right, 1
+	if peak-left > right-peak {
+		end, step = left, -1
+	}
+	//distance of (v, hist[v]) from the line through (peak, hist[peak])
+	//and (end, 0), up to a constant factor
+	h := float64(hist[peak])
+	t, best := peak, 0.0
+	for v := peak; v != end; v += step {
+		if d := h*float64(end-v)*float64(step) - float64(hist[v]*(end-peak)*step); d > best {
+			best, t = d, v
+		}
+	}
+	return uint8(t)
+}